@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"omnirouter/internal/router"
+	"os"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ * `omnirouter routes [-addr host:port] [-token t] [-diff] [-interval d]`
+ *
+ * Prints the route table of a running router through its admin API. With
+ * -diff it keeps polling and prints the registrations added (+) and removed
+ * (-) whenever the table changes, e.g. after a module reload.
+ */
+func routesCommand(args []string) int {
+	fs := flag.NewFlagSet("routes", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:9090", "admin listener address")
+	token := fs.String("token", os.Getenv("OMNIROUTER_ADMIN_TOKEN"), "admin token (default $OMNIROUTER_ADMIN_TOKEN)")
+	diff := fs.Bool("diff", false, "keep polling and print changes")
+	interval := fs.Duration("interval", time.Second, "poll interval with -diff")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	prev, err := fetchRoutes(*addr, *token)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "routes:", err)
+		return 1
+	}
+	for _, ri := range prev {
+		fmt.Println(ri.String())
+	}
+	if !*diff {
+		return 0
+	}
+
+	for range time.Tick(*interval) {
+		next, err := fetchRoutes(*addr, *token)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "routes:", err)
+			continue
+		}
+		added, removed := router.DiffRoutes(prev, next)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		fmt.Println("@", time.Now().Format(time.RFC3339))
+		for _, ri := range removed {
+			fmt.Println("-", ri.String())
+		}
+		for _, ri := range added {
+			fmt.Println("+", ri.String())
+		}
+		prev = next
+	}
+	return 0
+}
+
+func fetchRoutes(addr, token string) ([]router.RouteInfo, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI("http://" + addr + "/routes")
+	req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+	if err := fasthttp.DoTimeout(req, resp, 5*time.Second); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return nil, fmt.Errorf("admin API answered %d: %s", resp.StatusCode(), resp.Body())
+	}
+
+	var routes []router.RouteInfo
+	if err := json.Unmarshal(resp.Body(), &routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
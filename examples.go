@@ -0,0 +1,6 @@
+//go:build examples
+
+package main
+
+/* Native example modules, only in builds with `-tags examples` */
+import _ "omnirouter/examples/go/hello_world"
@@ -1,16 +1,28 @@
 #include <stdbool.h>
 #include <stddef.h>
+#include <stdio.h>
 
 #include "../../../../internal/modmgr/bridges/cffi.h"
 
 static void (*loginfo_)(char* msg, char* module_);
 
 void hello_world_handler(or_ctx_t* ctx, or_http_req_t* req, void* extra) {
-    loginfo_("Hello World triggered!", LOCATION);
+    char buf[256];
+    snprintf(buf, sizeof(buf), "Hello World triggered on %s (mount relative: %s)!", req->path, req->mount_path);
+    loginfo_(buf, LOCATION);
 }
 
+void hello_world_json_handler(or_ctx_t* ctx, or_http_req_t* req, void* extra) {
+    loginfo_("Hello World (JSON) triggered!", LOCATION);
+}
+
+static or_predicate_t json_preds[] = {
+    { .kind = OR_PRED_ACCEPT, .name = NULL, .value = "application/json" }
+};
+
 bool init(muid_t muid, const or_api_t* api) {
     api->register_http(muid, OR_METHOD_ANY, "/test/", hello_world_handler, NULL);
+    api->register_http_ex(muid, OR_METHOD_GET, "/test/", json_preds, 1, hello_world_json_handler, NULL);
     api->loginfo("Hello from the dynamically loaded library!", LOCATION);
     loginfo_ = api->loginfo;
     return true;
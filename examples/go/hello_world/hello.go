@@ -0,0 +1,35 @@
+/* Native counterpart of examples/c/hello_world, compiled in with `go build -tags examples` */
+package hello_world
+
+import (
+	"omnirouter/pkg/omnimod"
+
+	"github.com/valyala/fasthttp"
+)
+
+type helloGo struct{}
+
+func init() {
+	omnimod.Register("hellogo", helloGo{})
+}
+
+func (helloGo) Init(api omnimod.API) bool {
+	api.Log(omnimod.LOG_INFO, "Hello from a native Go module!")
+	return api.RegisterHTTP(omnimod.METHOD_GET, "/test/go", hello) == 0
+}
+
+func (helloGo) Uninit(api omnimod.API) bool {
+	return api.UnregisterHTTP(omnimod.METHOD_GET, "/test/go") == 0
+}
+
+func (helloGo) Handler(name string) (omnimod.Handler, bool) {
+	if name == "hello_go_handler" {
+		return hello, true
+	}
+	return nil, false
+}
+
+func hello(ctx *fasthttp.RequestCtx, req *omnimod.Request) {
+	ctx.SetContentType("text/plain")
+	ctx.WriteString("Hello from Go! You requested " + req.Path + "\n")
+}
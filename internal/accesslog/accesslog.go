@@ -0,0 +1,218 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+/* Apache/nginx log time, e.g. [10/Oct/2000:13:55:36 -0700] */
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+/* What the router knows about the request beyond the RequestCtx */
+type Entry struct {
+	Route     string
+	Module    string
+	RequestID string
+	Latency   time.Duration
+}
+
+type accessLog struct {
+	w      *lumberjack.Logger
+	format string
+	sample float64
+
+	latency, bytes, route, module, requestID, clientIP bool
+}
+
+var (
+	current atomic.Pointer[accessLog]
+	bufPool = sync.Pool{New: func() any { b := make([]byte, 0, 512); return &b }}
+)
+
+func Setup(conf *config.Config) {
+	ac := conf.AccessLog
+	if ac.File == "" {
+		return
+	}
+
+	has := func(f string) bool { return slices.Contains(ac.Fields, f) }
+	current.Store(&accessLog{
+		w: &lumberjack.Logger{
+			Filename:   ac.File,
+			MaxSize:    ac.MaxSizeMB,
+			MaxBackups: ac.MaxBackups,
+			MaxAge:     ac.MaxAgeDays,
+			Compress:   ac.Compress,
+		},
+		format:    ac.Format,
+		sample:    ac.SampleRate,
+		latency:   has(config.FIELD_LATENCY),
+		bytes:     has(config.FIELD_BYTES),
+		route:     has(config.FIELD_ROUTE),
+		module:    has(config.FIELD_MODULE),
+		requestID: has(config.FIELD_REQUEST_ID),
+		clientIP:  has(config.FIELD_CLIENT_IP),
+	})
+	logger.Info("Writing access log", "file", ac.File, "format", ac.Format, "sample_rate", ac.SampleRate)
+}
+
+/* Called once the response is complete, a no-op without an access log */
+func Log(ctx *fasthttp.RequestCtx, e Entry) {
+	a := current.Load()
+	if a == nil || (a.sample < 1 && rand.Float64() >= a.sample) {
+		return
+	}
+
+	bp := bufPool.Get().(*[]byte)
+	b := (*bp)[:0]
+	if a.format == config.ACCESS_JSON {
+		b = a.appendJSON(b, ctx, e)
+	} else {
+		b = a.appendText(b, ctx, e)
+	}
+	if _, err := a.w.Write(b); err != nil {
+		logger.Error("Could not write access log", "err", err.Error())
+	}
+	*bp = b
+	bufPool.Put(bp)
+}
+
+/* Body streams (static files) are not read, their Content-Length is used instead */
+func responseBytes(ctx *fasthttp.RequestCtx) int {
+	if ctx.Response.IsBodyStream() {
+		return max(ctx.Response.Header.ContentLength(), 0)
+	}
+	return len(ctx.Response.Body())
+}
+
+/* host ident authuser [time] "request" status bytes ["referer" "user-agent"] key=value... */
+func (a *accessLog) appendText(b []byte, ctx *fasthttp.RequestCtx, e Entry) []byte {
+	if a.clientIP {
+		b = append(b, ctx.RemoteIP().String()...)
+	} else {
+		b = append(b, '-')
+	}
+	b = append(b, " - - ["...)
+	b = ctx.Time().AppendFormat(b, clfTimeFormat)
+	b = append(b, "] \""...)
+	b = appendEscaped(b, ctx.Method())
+	b = append(b, ' ')
+	b = appendEscaped(b, ctx.RequestURI())
+	b = append(b, ' ')
+	b = appendEscaped(b, ctx.Request.Header.Protocol())
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(ctx.Response.StatusCode()), 10)
+	b = append(b, ' ')
+	if a.bytes {
+		b = strconv.AppendInt(b, int64(responseBytes(ctx)), 10)
+	} else {
+		b = append(b, '-')
+	}
+
+	if a.format == config.ACCESS_COMBINED {
+		b = appendQuoted(append(b, ' '), ctx.Referer())
+		b = appendQuoted(append(b, ' '), ctx.UserAgent())
+	}
+
+	if a.latency {
+		b = append(b, " latency="...)
+		b = strconv.AppendFloat(b, e.Latency.Seconds(), 'f', 6, 64)
+	}
+	if a.route {
+		b = appendQuoted(append(b, " route="...), []byte(e.Route))
+	}
+	if a.module {
+		b = appendQuoted(append(b, " module="...), []byte(e.Module))
+	}
+	if a.requestID {
+		b = appendQuoted(append(b, " request_id="...), []byte(e.RequestID))
+	}
+	return append(b, '\n')
+}
+
+type jsonEntry struct {
+	Time      string   `json:"time"`
+	ClientIP  string   `json:"client_ip,omitempty"`
+	Method    string   `json:"method"`
+	URI       string   `json:"uri"`
+	Protocol  string   `json:"protocol"`
+	Status    int      `json:"status"`
+	Bytes     *int     `json:"bytes,omitempty"`
+	Referer   string   `json:"referer,omitempty"`
+	UserAgent string   `json:"user_agent,omitempty"`
+	Latency   *float64 `json:"latency,omitempty"`
+	Route     *string  `json:"route,omitempty"`
+	Module    *string  `json:"module,omitempty"`
+	RequestID *string  `json:"request_id,omitempty"`
+}
+
+func (a *accessLog) appendJSON(b []byte, ctx *fasthttp.RequestCtx, e Entry) []byte {
+	je := jsonEntry{
+		Time:      ctx.Time().Format(time.RFC3339Nano),
+		Method:    string(ctx.Method()),
+		URI:       string(ctx.RequestURI()),
+		Protocol:  string(ctx.Request.Header.Protocol()),
+		Status:    ctx.Response.StatusCode(),
+		Referer:   string(ctx.Referer()),
+		UserAgent: string(ctx.UserAgent()),
+	}
+	if a.clientIP {
+		je.ClientIP = ctx.RemoteIP().String()
+	}
+	if a.bytes {
+		n := responseBytes(ctx)
+		je.Bytes = &n
+	}
+	if a.latency {
+		s := e.Latency.Seconds()
+		je.Latency = &s
+	}
+	if a.route {
+		je.Route = &e.Route
+	}
+	if a.module {
+		je.Module = &e.Module
+	}
+	if a.requestID {
+		je.RequestID = &e.RequestID
+	}
+
+	out, err := json.Marshal(je)
+	if err != nil {
+		return b
+	}
+	return append(append(b, out...), '\n')
+}
+
+/* "-" for empty values, as in the standard formats */
+func appendQuoted(b, v []byte) []byte {
+	if len(v) == 0 {
+		return append(b, `"-"`...)
+	}
+	b = append(b, '"')
+	b = appendEscaped(b, v)
+	return append(b, '"')
+}
+
+/* Quotes, backslashes and non-printable bytes are \xNN escaped so a line can't be forged */
+func appendEscaped(b, v []byte) []byte {
+	const hexDigits = "0123456789ABCDEF"
+	for _, c := range v {
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			b = append(b, '\\', 'x', hexDigits[c>>4], hexDigits[c&0xf])
+			continue
+		}
+		b = append(b, c)
+	}
+	return b
+}
@@ -0,0 +1,233 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/metrics"
+	"omnirouter/internal/modmgr"
+	"omnirouter/internal/router"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ * Admin endpoints, all JSON:
+ *
+ *   GET  /modules                  every module with its routes
+ *   GET  /modules/<name>           one module, by name, file name or MUID
+ *   POST /modules/<name>/<action>  load, unload, reload, enable or disable
+ *   GET  /routes                   the route table with owners
+ *   GET  /metrics                  Prometheus metrics, as on the main listener
+ *   /logs...                       log buffer and levels, see logs.go
+ *   /events...                     module lifecycle events, see events.go
+ *   /debug/...                     profiling and runtime stats if enabled, see debug.go
+ */
+type server struct {
+	token []byte
+	done  <-chan struct{} /* ends open streams when the listener stops */
+	pprof bool
+	debug bool
+}
+
+type moduleStatus struct {
+	modmgr.ModuleInfo
+	Routes []router.RouteInfo `json:"routes"`
+}
+
+var moduleActions = map[string]func(string) error{
+	"load":    modmgr.LoadModule,
+	"unload":  modmgr.UnloadModule,
+	"reload":  modmgr.RestartModule,
+	"enable":  modmgr.EnableModule,
+	"disable": modmgr.DisableModule,
+}
+
+/* Starts the admin listener if configured, it stops with ctx */
+func Setup(ctx context.Context, conf *config.Config) {
+	if conf.Admin.Listen == "" {
+		return
+	}
+
+	srv := &server{
+		token: []byte(conf.Admin.Token),
+		done:  ctx.Done(),
+		pprof: conf.Admin.Pprof,
+		debug: conf.Admin.Debug,
+	}
+	s := &fasthttp.Server{
+		Handler:               srv.serve,
+		Name:                  "OmniRouter admin",
+		NoDefaultServerHeader: true,
+		ReadTimeout:           10 * time.Second,
+		WriteTimeout:          20 * time.Second,
+		MaxRequestBodySize:    1 << 20,
+	}
+
+	ln, err := net.Listen("tcp", conf.Admin.Listen)
+	if err != nil {
+		logger.Error("Admin listener could not be started", "addr", conf.Admin.Listen, "err", err.Error())
+		return
+	}
+	logger.Info("Running admin API on address", "addr", conf.Admin.Listen)
+	metrics.RegisterServer("admin", s)
+
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			logger.Error("Admin listener stopped", "err", err.Error())
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		sdCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := s.ShutdownWithContext(sdCtx); err != nil {
+			logger.Warn("Admin listener could not be shut down cleanly", "err", err)
+		}
+	}()
+}
+
+func (s *server) authorized(ctx *fasthttp.RequestCtx) bool {
+	auth := ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)
+	token, ok := strings.CutPrefix(string(auth), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), s.token) == 1
+}
+
+func (s *server) serve(ctx *fasthttp.RequestCtx) {
+	if !s.authorized(ctx) {
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer realm="omnirouter"`)
+		writeError(ctx, fasthttp.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	path := strings.TrimSuffix(string(ctx.Path()), "/")
+	switch {
+	case path == "/modules":
+		if requireMethod(ctx, fasthttp.MethodGet) {
+			listModules(ctx)
+		}
+	case path == "/metrics":
+		if requireMethod(ctx, fasthttp.MethodGet) {
+			metrics.Serve(ctx)
+		}
+	case path == "/routes":
+		if requireMethod(ctx, fasthttp.MethodGet) {
+			writeJSON(ctx, fasthttp.StatusOK, router.GetHTTPRouter().Routes())
+		}
+	case path == "/logs" || strings.HasPrefix(path, "/logs/"):
+		s.serveLogs(ctx, strings.TrimPrefix(strings.TrimPrefix(path, "/logs"), "/"))
+	case path == "/events" || strings.HasPrefix(path, "/events/"):
+		s.serveEvents(ctx, strings.TrimPrefix(strings.TrimPrefix(path, "/events"), "/"))
+	case strings.HasPrefix(path, "/debug/"):
+		s.serveDebug(ctx, strings.TrimPrefix(path, "/debug/"))
+	case strings.HasPrefix(path, "/modules/"):
+		name, action, _ := strings.Cut(strings.TrimPrefix(path, "/modules/"), "/")
+		if action == "" {
+			if requireMethod(ctx, fasthttp.MethodGet) {
+				getModule(ctx, name)
+			}
+			return
+		}
+		fn, ok := moduleActions[action]
+		if !ok {
+			writeError(ctx, fasthttp.StatusNotFound, "unknown action "+action)
+			return
+		}
+		if requireMethod(ctx, fasthttp.MethodPost) {
+			moduleAction(ctx, name, action, fn)
+		}
+	default:
+		writeError(ctx, fasthttp.StatusNotFound, "not found")
+	}
+}
+
+func modules() []moduleStatus {
+	routes := router.GetHTTPRouter().Routes()
+	infos := modmgr.List()
+	out := make([]moduleStatus, 0, len(infos))
+	for _, info := range infos {
+		ms := moduleStatus{ModuleInfo: info, Routes: []router.RouteInfo{}}
+		for _, r := range routes {
+			if r.Owner == info.Name {
+				ms.Routes = append(ms.Routes, r)
+			}
+		}
+		out = append(out, ms)
+	}
+	return out
+}
+
+func findModule(name string) (moduleStatus, bool) {
+	for _, ms := range modules() {
+		if ms.Name == name || strings.EqualFold(ms.MUID, name) || (ms.Path != "" && strings.HasSuffix(ms.Path, "/"+name)) {
+			return ms, true
+		}
+	}
+	return moduleStatus{}, false
+}
+
+func listModules(ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, fasthttp.StatusOK, modules())
+}
+
+func getModule(ctx *fasthttp.RequestCtx, name string) {
+	ms, ok := findModule(name)
+	if !ok {
+		writeError(ctx, fasthttp.StatusNotFound, modmgr.ErrUnknownModule.Error())
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusOK, ms)
+}
+
+func moduleAction(ctx *fasthttp.RequestCtx, name, action string, fn func(string) error) {
+	logger.Info("Admin module action", "module", name, "action", action, "remote", ctx.RemoteAddr().String())
+	if err := fn(name); err != nil {
+		status := fasthttp.StatusInternalServerError
+		switch {
+		case errors.Is(err, modmgr.ErrUnknownModule):
+			status = fasthttp.StatusNotFound
+		case errors.Is(err, modmgr.ErrModuleDisabled),
+			errors.Is(err, modmgr.ErrModuleLoaded),
+			errors.Is(err, modmgr.ErrModuleNotLoaded):
+			status = fasthttp.StatusConflict
+		}
+		writeError(ctx, status, err.Error())
+		return
+	}
+
+	if ms, ok := findModule(name); ok {
+		writeJSON(ctx, fasthttp.StatusOK, ms)
+	} else {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	}
+}
+
+func requireMethod(ctx *fasthttp.RequestCtx, method string) bool {
+	if string(ctx.Method()) == method {
+		return true
+	}
+	ctx.Response.Header.Set(fasthttp.HeaderAllow, method)
+	writeError(ctx, fasthttp.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+func writeError(ctx *fasthttp.RequestCtx, status int, msg string) {
+	writeJSON(ctx, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"omnirouter/internal/modmgr"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/pprofhandler"
+)
+
+/*
+ * Runtime debugging, each group only when enabled in the config:
+ *
+ *   /debug/pprof/...            net/http/pprof, for `go tool pprof` (pprof)
+ *   GET /debug/goroutines       stack dump of every goroutine, ?debug=1
+ *                               groups identical stacks (debug)
+ *   GET /debug/gc               GC and heap stats (debug)
+ *   GET /debug/cgo              cgo calls and time spent in C handlers (debug)
+ */
+type gcStats struct {
+	NumGC         int64     `json:"num_gc"`
+	LastGC        time.Time `json:"last_gc"`
+	PauseTotal    float64   `json:"pause_total_seconds"`
+	RecentPauses  []float64 `json:"recent_pauses_seconds"` /* newest first */
+	GCCPUFraction float64   `json:"gc_cpu_fraction"`
+	HeapAlloc     uint64    `json:"heap_alloc_bytes"`
+	HeapInuse     uint64    `json:"heap_inuse_bytes"`
+	HeapSys       uint64    `json:"heap_sys_bytes"`
+	HeapObjects   uint64    `json:"heap_objects"`
+	NextGC        uint64    `json:"next_gc_bytes"`
+	TotalAlloc    uint64    `json:"total_alloc_bytes"`
+	Sys           uint64    `json:"sys_bytes"`
+	Goroutines    int       `json:"goroutines"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	GOGC          int64     `json:"gogc"`               /* 0 when off */
+	MemoryLimit   uint64    `json:"memory_limit_bytes"` /* math.MaxInt64 when unset */
+}
+
+const recentPauses = 16
+
+func (s *server) serveDebug(ctx *fasthttp.RequestCtx, sub string) {
+	if s.pprof && (sub == "pprof" || strings.HasPrefix(sub, "pprof/")) {
+		pprofhandler.PprofHandler(ctx)
+		return
+	}
+	if !s.debug {
+		writeError(ctx, fasthttp.StatusNotFound, "not found")
+		return
+	}
+
+	switch sub {
+	case "goroutines":
+		if !requireMethod(ctx, fasthttp.MethodGet) {
+			return
+		}
+		level := 2
+		if v, err := strconv.Atoi(string(ctx.QueryArgs().Peek("debug"))); err == nil && v == 1 {
+			level = 1
+		}
+		ctx.SetContentType("text/plain; charset=utf-8")
+		pprof.Lookup("goroutine").WriteTo(ctx, level)
+	case "gc":
+		if requireMethod(ctx, fasthttp.MethodGet) {
+			writeJSON(ctx, fasthttp.StatusOK, readGCStats())
+		}
+	case "cgo":
+		if requireMethod(ctx, fasthttp.MethodGet) {
+			writeJSON(ctx, fasthttp.StatusOK, modmgr.GetCgoStats())
+		}
+	default:
+		writeError(ctx, fasthttp.StatusNotFound, "not found")
+	}
+}
+
+func readGCStats() gcStats {
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	settings := []metrics.Sample{{Name: "/gc/gogc:percent"}, {Name: "/gc/gomemlimit:bytes"}}
+	metrics.Read(settings)
+
+	if len(gc.Pause) > recentPauses {
+		gc.Pause = gc.Pause[:recentPauses]
+	}
+	pauses := make([]float64, 0, len(gc.Pause))
+	for _, p := range gc.Pause {
+		pauses = append(pauses, p.Seconds())
+	}
+	return gcStats{
+		NumGC:         gc.NumGC,
+		LastGC:        gc.LastGC,
+		PauseTotal:    gc.PauseTotal.Seconds(),
+		RecentPauses:  pauses,
+		GCCPUFraction: mem.GCCPUFraction,
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapSys:       mem.HeapSys,
+		HeapObjects:   mem.HeapObjects,
+		NextGC:        mem.NextGC,
+		TotalAlloc:    mem.TotalAlloc,
+		Sys:           mem.Sys,
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		GOGC:          int64(settings[0].Value.Uint64()),
+		MemoryLimit:   settings[1].Value.Uint64(),
+	}
+}
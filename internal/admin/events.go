@@ -0,0 +1,327 @@
+package admin
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"omnirouter/internal/modmgr"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ * Module lifecycle events:
+ *
+ *   GET /events?module=&type=&version=         buffered events, oldest first
+ *   GET /events/stream?module=&type=&version=  events as server-sent events,
+ *                                              or WebSocket text frames when
+ *                                              the request asks for an upgrade
+ *
+ * type takes a comma separated list, version matches a prefix of the hash.
+ * Streams replay the buffered events after ?since= (or Last-Event-ID) first,
+ * so a client can subscribe after triggering a reload without a race.
+ */
+type eventFilter struct {
+	module  string
+	types   map[modmgr.EventType]bool
+	version string
+}
+
+func (f eventFilter) match(e modmgr.Event) bool {
+	if f.module != "" && e.Module != f.module {
+		return false
+	}
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	return strings.HasPrefix(e.Version, f.version)
+}
+
+func (s *server) serveEvents(ctx *fasthttp.RequestCtx, sub string) {
+	if !requireMethod(ctx, fasthttp.MethodGet) {
+		return
+	}
+	f := eventsFilter(ctx)
+	switch sub {
+	case "":
+		out := []modmgr.Event{}
+		for _, e := range modmgr.RecentEvents() {
+			if f.match(e) {
+				out = append(out, e)
+			}
+		}
+		writeJSON(ctx, fasthttp.StatusOK, out)
+	case "stream":
+		since := ctx.Request.Header.Peek("Last-Event-ID")
+		if v := ctx.QueryArgs().Peek("since"); len(v) > 0 {
+			since = v
+		}
+		seq, _ := strconv.ParseUint(string(since), 10, 64)
+		if ctx.Request.Header.ConnectionUpgrade() && strings.EqualFold(string(ctx.Request.Header.Peek(fasthttp.HeaderUpgrade)), "websocket") {
+			s.websocketEvents(ctx, f, seq)
+		} else {
+			s.streamEvents(ctx, f, seq)
+		}
+	default:
+		writeError(ctx, fasthttp.StatusNotFound, "not found")
+	}
+}
+
+func eventsFilter(ctx *fasthttp.RequestCtx) eventFilter {
+	f := eventFilter{module: moduleArg(ctx), version: string(ctx.QueryArgs().Peek("version"))}
+	if v := ctx.QueryArgs().Peek("type"); len(v) > 0 {
+		f.types = map[modmgr.EventType]bool{}
+		for _, t := range strings.Split(string(v), ",") {
+			f.types[modmgr.EventType(strings.TrimSpace(t))] = true
+		}
+	}
+	return f
+}
+
+/* The replayed backlog followed by live events matching f, in order */
+func filteredEvents(f eventFilter, since uint64) (<-chan modmgr.Event, func()) {
+	backlog, live, unsubscribe := modmgr.Subscribe(since, sseBuffer)
+	out := make(chan modmgr.Event, sseBuffer)
+	stop := make(chan struct{})
+
+	go func() {
+		var last uint64
+		send := func(e modmgr.Event) bool {
+			/* An event can be both buffered and delivered live */
+			if e.Seq <= last || !f.match(e) {
+				return true
+			}
+			last = e.Seq
+			select {
+			case out <- e:
+			case <-stop:
+				return false
+			}
+			return true
+		}
+		for _, e := range backlog {
+			if !send(e) {
+				return
+			}
+		}
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-live:
+				if !send(e) {
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return out, func() {
+		once.Do(func() {
+			unsubscribe()
+			close(stop)
+		})
+	}
+}
+
+func (s *server) streamEvents(ctx *fasthttp.RequestCtx, f eventFilter, since uint64) {
+	events, cancel := filteredEvents(f, since)
+	out := make(chan sseEvent)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-events:
+				select {
+				case out <- sseEvent{id: e.Seq, name: string(e.Type), data: e}:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	s.streamSSE(ctx, out, func() {
+		cancel()
+		close(stop)
+	})
+}
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC11B65"
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xa
+
+	wsMaxControl = 125
+	wsMaxMessage = 1 << 20 /* nothing is expected from the client anyway */
+)
+
+/*
+ * Just enough of RFC 6455 for a one-way feed: every event is a text frame
+ * holding its JSON, the client's messages are read only to answer pings and
+ * closes.
+ */
+func (s *server) websocketEvents(ctx *fasthttp.RequestCtx, f eventFilter, since uint64) {
+	key := ctx.Request.Header.Peek("Sec-WebSocket-Key")
+	if len(key) == 0 || string(ctx.Request.Header.Peek("Sec-WebSocket-Version")) != "13" {
+		ctx.Response.Header.Set("Sec-WebSocket-Version", "13")
+		writeError(ctx, fasthttp.StatusBadRequest, "unsupported websocket handshake")
+		return
+	}
+	sum := sha1.Sum(append(append([]byte(nil), key...), wsGUID...))
+
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set(fasthttp.HeaderUpgrade, "websocket")
+	ctx.Response.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	ctx.Response.Header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+
+	done := s.done
+	ctx.Hijack(func(conn net.Conn) {
+		events, cancel := filteredEvents(f, since)
+		defer cancel()
+		ws := &wsConn{conn: conn}
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			ws.readLoop()
+		}()
+
+		ping := time.NewTicker(sseKeepAlive)
+		defer ping.Stop()
+		for {
+			select {
+			case <-done:
+				ws.close()
+				return
+			case <-closed:
+				return
+			case <-ping.C:
+				if ws.write(wsOpPing, nil) != nil {
+					return
+				}
+			case e := <-events:
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				if ws.write(wsOpText, data) != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+type wsConn struct {
+	mu   sync.Mutex /* serializes frames from the event loop and readLoop */
+	conn net.Conn
+}
+
+func (ws *wsConn) write(op byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+	_, err := (&net.Buffers{hdr, payload}).WriteTo(ws.conn)
+	return err
+}
+
+/* Also drops the connection, the client's reply is not waited for */
+func (ws *wsConn) close() {
+	ws.write(wsOpClose, []byte{0x03, 0xe9}) /* 1001 going away */
+	ws.conn.Close()
+}
+
+/* Until the client closes, errs or sends something it may not */
+func (ws *wsConn) readLoop() {
+	defer ws.conn.Close()
+	r := bufio.NewReader(ws.conn)
+	var hdr [2]byte
+	for {
+		ws.conn.SetReadDeadline(time.Time{})
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		op, masked, n := hdr[0]&0x0f, hdr[1]&0x80 != 0, uint64(hdr[1]&0x7f)
+		if !masked {
+			return
+		}
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+
+		/* Data frames are ignored, control frames are small by definition */
+		if op&0x8 == 0 {
+			if n > wsMaxMessage {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+				return
+			}
+			continue
+		}
+		if n > wsMaxControl {
+			return
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch op {
+		case wsOpClose:
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			ws.write(wsOpClose, payload)
+			return
+		case wsOpPing:
+			if ws.write(wsOpPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package admin
+
+import (
+	"omnirouter/internal/logger"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ * Log endpoints:
+ *
+ *   GET    /logs?module=&level=&limit=  recent entries from the in-memory buffer
+ *   GET    /logs/stream?module=&level=  new entries as server-sent events
+ *   GET    /logs/level                  the global and per-module levels
+ *   POST   /logs/level?level=[&module=] sets the global or a module's level
+ *   DELETE /logs/level?module=          a module back to the global level
+ */
+type logLevels struct {
+	Global  string            `json:"global"`
+	Modules map[string]string `json:"modules"`
+}
+
+func (s *server) serveLogs(ctx *fasthttp.RequestCtx, sub string) {
+	switch sub {
+	case "":
+		if !requireMethod(ctx, fasthttp.MethodGet) {
+			return
+		}
+		f, ok := logFilter(ctx)
+		if !ok {
+			return
+		}
+		limit, _ := strconv.Atoi(string(ctx.QueryArgs().Peek("limit")))
+		writeJSON(ctx, fasthttp.StatusOK, logger.Recent(f, limit))
+	case "stream":
+		if requireMethod(ctx, fasthttp.MethodGet) {
+			s.streamLogs(ctx)
+		}
+	case "level":
+		switch string(ctx.Method()) {
+		case fasthttp.MethodGet:
+			writeJSON(ctx, fasthttp.StatusOK, currentLevels())
+		case fasthttp.MethodPost:
+			setLevel(ctx)
+		case fasthttp.MethodDelete:
+			clearLevel(ctx)
+		default:
+			ctx.Response.Header.Set(fasthttp.HeaderAllow, "GET, POST, DELETE")
+			writeError(ctx, fasthttp.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeError(ctx, fasthttp.StatusNotFound, "not found")
+	}
+}
+
+/* Module names are resolved like in /modules, so a file name or MUID works too */
+func logFilter(ctx *fasthttp.RequestCtx) (logger.Filter, bool) {
+	f := logger.Filter{Module: moduleArg(ctx), Level: zerolog.TraceLevel}
+	if v := ctx.QueryArgs().Peek("level"); len(v) > 0 {
+		level, err := logger.ParseLevel(string(v))
+		if err != nil {
+			writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+			return f, false
+		}
+		f.Level = level
+	}
+	return f, true
+}
+
+func moduleArg(ctx *fasthttp.RequestCtx) string {
+	name := string(ctx.QueryArgs().Peek("module"))
+	if ms, ok := findModule(name); ok && name != "" {
+		return ms.Name
+	}
+	return name
+}
+
+func (s *server) streamLogs(ctx *fasthttp.RequestCtx) {
+	f, ok := logFilter(ctx)
+	if !ok {
+		return
+	}
+
+	entries, unsubscribe := logger.Subscribe(sseBuffer)
+	events := make(chan sseEvent, sseBuffer)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-entries:
+				if !f.Match(e) {
+					continue
+				}
+				select {
+				case events <- sseEvent{id: e.Seq, data: e}:
+				default:
+				}
+			}
+		}
+	}()
+	s.streamSSE(ctx, events, func() {
+		unsubscribe()
+		close(stop)
+	})
+}
+
+func currentLevels() logLevels {
+	out := logLevels{Global: logger.Level().String(), Modules: map[string]string{}}
+	for m, l := range logger.ModuleLevels() {
+		out.Modules[m] = l.String()
+	}
+	return out
+}
+
+func setLevel(ctx *fasthttp.RequestCtx) {
+	level, err := logger.ParseLevel(string(ctx.QueryArgs().Peek("level")))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, "level: "+err.Error())
+		return
+	}
+
+	if module := moduleArg(ctx); module != "" {
+		logger.SetModuleLevel(module, level)
+		logger.Info("Admin set module log level", "module", module, "log_level", level.String(), "remote", ctx.RemoteAddr().String())
+	} else {
+		logger.SetLevel(level)
+		logger.Info("Admin set log level", "log_level", level.String(), "remote", ctx.RemoteAddr().String())
+	}
+	writeJSON(ctx, fasthttp.StatusOK, currentLevels())
+}
+
+func clearLevel(ctx *fasthttp.RequestCtx) {
+	module := moduleArg(ctx)
+	if module == "" {
+		writeError(ctx, fasthttp.StatusBadRequest, "module is required")
+		return
+	}
+	logger.ClearModuleLevel(module)
+	logger.Info("Admin cleared module log level", "module", module, "remote", ctx.RemoteAddr().String())
+	writeJSON(ctx, fasthttp.StatusOK, currentLevels())
+}
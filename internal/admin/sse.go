@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	sseKeepAlive    = 15 * time.Second
+	sseWriteTimeout = 30 * time.Second
+	sseBuffer       = 256
+)
+
+type sseEvent struct {
+	id   uint64 /* omitted when 0 */
+	name string /* omitted when empty, the client's default "message" */
+	data any    /* sent as JSON */
+}
+
+/*
+ * Streams events as server-sent events until the client goes away or the
+ * admin listener stops; cancel is called either way. A comment is sent when
+ * idle so a vanished client is noticed.
+ */
+func (s *server) streamSSE(ctx *fasthttp.RequestCtx, events <-chan sseEvent, cancel func()) {
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-cache")
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	conn, done := ctx.Conn(), s.done
+
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		ping := time.NewTicker(sseKeepAlive)
+		defer ping.Stop()
+
+		/* Flushed right away, so the client sees the stream is open */
+		w.WriteString(": stream open\n\n")
+		for {
+			conn.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ping.C:
+				w.WriteString(": ping\n\n")
+			case ev := <-events:
+				data, err := json.Marshal(ev.data)
+				if err != nil {
+					continue
+				}
+				if ev.id != 0 {
+					w.WriteString("id: " + strconv.FormatUint(ev.id, 10) + "\n")
+				}
+				if ev.name != "" {
+					w.WriteString("event: " + ev.name + "\n")
+				}
+				w.WriteString("data: ")
+				w.Write(data)
+				w.WriteString("\n\n")
+			}
+		}
+	})
+}
@@ -9,6 +9,7 @@ const (
 	CAP_HTTP_REGISTER          Capabilities = 1 << 3
 	CAP_HTTP_REGISTER_WILDCARD Capabilities = 1 << 4
 	CAP_HTTP_UNREGISTER        Capabilities = 1 << 5
+	CAP_METRICS                Capabilities = 1 << 6
 )
 
 func HasCapabilities(capset Capabilities, capabilities Capabilities) bool {
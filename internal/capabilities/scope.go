@@ -0,0 +1,173 @@
+package capabilities
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+var capNames = map[string]Capabilities{
+	"logging":                CAP_LOGGING,
+	"logging_fatal":          CAP_LOGGING_FATAL,
+	"http_register":          CAP_HTTP_REGISTER,
+	"http_register_wildcard": CAP_HTTP_REGISTER_WILDCARD,
+	"http_unregister":        CAP_HTTP_UNREGISTER,
+	"metrics":                CAP_METRICS,
+}
+
+/*
+ * A path scope restricts a capability to an exact path (`/api/billing`) or a
+ * whole subtree (`/api/billing/*`). Only meaningful for the HTTP capabilities.
+ */
+type Scope struct {
+	Prefix  string
+	Subtree bool
+}
+
+/*
+ * Capability set of a module. A capability granted without a scope is not
+ * restricted, one granted only with scopes is limited to the union of them.
+ */
+type Set struct {
+	Mask   Capabilities
+	scopes map[Capabilities][]Scope
+}
+
+func NewSet(mask Capabilities) Set {
+	return Set{Mask: mask}
+}
+
+/* For routes the router sets up by itself, never hand this to a module */
+func Unrestricted() Set {
+	return Set{Mask: ^CAP_NONE}
+}
+
+/* Parses config grants such as `logging` or `http_register:/api/billing/*` */
+func ParseSet(grants []string) (Set, error) {
+	var set Set
+	unscoped := CAP_NONE
+	for _, g := range grants {
+		name, scope, hasScope := strings.Cut(strings.TrimSpace(g), ":")
+		c, ok := capNames[strings.ToLower(name)]
+		if !ok {
+			return Set{}, fmt.Errorf("unknown capability %q", name)
+		}
+
+		set.Mask |= c
+		if !hasScope {
+			unscoped |= c
+			continue
+		}
+
+		s, err := parseScope(scope)
+		if err != nil {
+			return Set{}, fmt.Errorf("capability %q: %w", g, err)
+		}
+		if set.scopes == nil {
+			set.scopes = make(map[Capabilities][]Scope)
+		}
+		set.scopes[c] = append(set.scopes[c], s)
+	}
+
+	/* An unscoped grant wins over any scoped one of the same capability */
+	for c := range set.scopes {
+		if HasCapabilities(unscoped, c) {
+			delete(set.scopes, c)
+		}
+	}
+	return set, nil
+}
+
+func parseScope(s string) (Scope, error) {
+	if !strings.HasPrefix(s, "/") {
+		return Scope{}, fmt.Errorf("scope %q must be an absolute path", s)
+	}
+
+	subtree := false
+	if s == "/*" || strings.HasSuffix(s, "/*") {
+		subtree = true
+		s = strings.TrimSuffix(s, "*")
+	}
+	if strings.Contains(s, "*") {
+		return Scope{}, fmt.Errorf("scope %q may only end in /*", s)
+	}
+
+	return Scope{Prefix: path.Clean(s), Subtree: subtree}, nil
+}
+
+func (s Set) Has(c Capabilities) bool {
+	return HasCapabilities(s.Mask, c)
+}
+
+/*
+ * Reports whether `c` may be used on the path. A wildcard route covers its
+ * whole subtree, so it needs a subtree scope to be allowed. Scoped paths may
+ * not contain `.` or `..` segments, `/a/../b` would pass a check on `/a/*`.
+ */
+func (s Set) AllowsPath(c Capabilities, p string, wildcard bool) bool {
+	if !s.Has(c) {
+		return false
+	}
+
+	scopes, ok := s.scopes[c]
+	if !ok {
+		return true
+	}
+	if hasDotSegment(p) {
+		return false
+	}
+
+	for _, sc := range scopes {
+		if sc.covers(p, wildcard) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDotSegment(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func (sc Scope) covers(p string, wildcard bool) bool {
+	if !sc.Subtree {
+		return !wildcard && p == sc.Prefix
+	}
+	return sc.Prefix == "/" || p == sc.Prefix || strings.HasPrefix(p, sc.Prefix+"/")
+}
+
+func (sc Scope) String() string {
+	if !sc.Subtree {
+		return sc.Prefix
+	}
+	if sc.Prefix == "/" {
+		return "/*"
+	}
+	return sc.Prefix + "/*"
+}
+
+/* Same format ParseSet accepts, sorted */
+func (s Set) String() string {
+	var out []string
+	for name, c := range capNames {
+		if !s.Has(c) {
+			continue
+		}
+		scopes, ok := s.scopes[c]
+		if !ok {
+			out = append(out, name)
+			continue
+		}
+		for _, sc := range scopes {
+			out = append(out, name+":"+sc.String())
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
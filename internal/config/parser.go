@@ -2,13 +2,19 @@ package config
 
 import (
 	"fmt"
+	"omnirouter/internal/capabilities"
 	"omnirouter/internal/logger"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 func ParseConfig(path string) (*Config, error) {
-	var cfg Config
+	cfg := defaultConfig()
 	meta, err := toml.DecodeFile(path, &cfg)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Could not read configuration file \"%q\"", path))
@@ -25,6 +31,81 @@ func ParseConfig(path string) (*Config, error) {
 		cfg.Modules.Mirrorlib = "./mirrordir"
 	}
 
+	for name, mc := range cfg.Module {
+		if _, err := capabilities.ParseSet(mc.Capabilities); err != nil {
+			logger.Error(fmt.Sprintf("Invalid capabilities for module %q: %s", name, err))
+			return nil, fmt.Errorf("invalid capabilities for module %q: %w", name, err)
+		}
+
+		mount, ok := cleanMount(mc.Mount)
+		if !ok {
+			logger.Error(fmt.Sprintf("Invalid mount %q for module %q, must be an absolute path without wildcards", mc.Mount, name))
+			return nil, fmt.Errorf("invalid mount for module %q: %q", name, mc.Mount)
+		}
+		mc.Mount = mount
+
+		if err := checkSandbox(&mc.Sandbox); err != nil {
+			logger.Error(fmt.Sprintf("Invalid sandbox for module %q: %s", name, err))
+			return nil, fmt.Errorf("invalid sandbox for module %q: %w", name, err)
+		}
+		cfg.Module[name] = mc
+	}
+
+	for name, up := range cfg.Upstreams {
+		if err := checkUpstream(&up); err != nil {
+			logger.Error(fmt.Sprintf("Invalid upstream %q: %s", name, err))
+			return nil, fmt.Errorf("invalid upstream %q: %w", name, err)
+		}
+		cfg.Upstreams[name] = up
+	}
+
+	for i := range cfg.Routes {
+		if err := checkRoute(&cfg.Routes[i], &cfg); err != nil {
+			logger.Error(fmt.Sprintf("Invalid routes[%d]: %s", i, err))
+			return nil, fmt.Errorf("invalid routes[%d]: %w", i, err)
+		}
+	}
+
+	for i := range cfg.Rules {
+		if err := checkRule(&cfg.Rules[i]); err != nil {
+			logger.Error(fmt.Sprintf("Invalid rules[%d]: %s", i, err))
+			return nil, fmt.Errorf("invalid rules[%d]: %w", i, err)
+		}
+	}
+
+	if _, err := logger.ParseLevel(cfg.Log.Level); err != nil {
+		logger.Error(fmt.Sprintf("Invalid log.level %q", cfg.Log.Level))
+		return nil, fmt.Errorf("invalid log.level: %w", err)
+	}
+	if cfg.Log.Buffer < 0 {
+		logger.Error("Invalid log.buffer, must not be negative")
+		return nil, fmt.Errorf("invalid log.buffer %d", cfg.Log.Buffer)
+	}
+
+	if err := checkAccessLog(&cfg.AccessLog); err != nil {
+		logger.Error(fmt.Sprintf("Invalid access_log: %s", err))
+		return nil, fmt.Errorf("invalid access_log: %w", err)
+	}
+
+	if err := checkAdmin(&cfg.Admin); err != nil {
+		logger.Error(fmt.Sprintf("Invalid admin: %s", err))
+		return nil, fmt.Errorf("invalid admin: %w", err)
+	}
+
+	switch cfg.Router.Paths.EncodedSlash {
+	case "reject", "decode", "keep":
+	default:
+		logger.Warn(fmt.Sprintf("Invalid router.paths.encoded_slash %q, defaulting to \"reject\"", cfg.Router.Paths.EncodedSlash))
+		cfg.Router.Paths.EncodedSlash = "reject"
+	}
+
+	switch cfg.Router.Paths.DotSegments {
+	case "resolve", "reject":
+	default:
+		logger.Warn(fmt.Sprintf("Invalid router.paths.dot_segments %q, defaulting to \"resolve\"", cfg.Router.Paths.DotSegments))
+		cfg.Router.Paths.DotSegments = "resolve"
+	}
+
 	if undec := meta.Undecoded(); len(undec) > 0 {
 		for _, k := range undec {
 			logger.Warn(fmt.Sprintf("Unrecognized configuration key: %s", k.String()))
@@ -34,3 +115,275 @@ func ParseConfig(path string) (*Config, error) {
 	logger.Info(fmt.Sprintf("Configuration loaded from %s (modules.path=%q)", path, cfg.Modules.Path))
 	return &cfg, nil
 }
+
+func checkRoute(r *Route, cfg *Config) error {
+	if !strings.HasPrefix(r.Path, "/") || strings.Contains(r.Path, "*") {
+		return fmt.Errorf("path %q must be absolute, use wildcard = true instead of *", r.Path)
+	}
+
+	if r.Kind == "" {
+		r.Kind = ROUTE_MODULE
+	}
+
+	switch r.Kind {
+	case ROUTE_MODULE:
+		if r.Module == "" || r.Handler == "" {
+			return fmt.Errorf("missing module or handler")
+		}
+	case ROUTE_PROXY:
+		if _, ok := cfg.Upstreams[r.Upstream]; !ok {
+			return fmt.Errorf("unknown upstream %q", r.Upstream)
+		}
+	case ROUTE_STATIC:
+		if fi, err := os.Stat(r.Static.Root); err != nil || !fi.IsDir() {
+			return fmt.Errorf("static.root %q is not a directory", r.Static.Root)
+		}
+		for _, rule := range r.Static.CacheControl {
+			if _, err := path.Match(rule.Match, ""); err != nil {
+				return fmt.Errorf("invalid cache_control match %q", rule.Match)
+			}
+		}
+		r.Wildcard = true
+		if len(r.Methods) == 0 {
+			r.Methods = []string{"GET", "HEAD"}
+		}
+	case ROUTE_CGI, ROUTE_FASTCGI:
+		gw := &r.Gateway
+		if (gw.Script == "") == (gw.Root == "") {
+			return fmt.Errorf("exactly one of gateway.script and gateway.root is required")
+		}
+		if r.Kind == ROUTE_CGI && gw.Script != "" {
+			if fi, err := os.Stat(gw.Script); err != nil || fi.IsDir() {
+				return fmt.Errorf("gateway.script %q is not a file", gw.Script)
+			}
+		}
+		if r.Kind == ROUTE_CGI && gw.Root != "" {
+			if fi, err := os.Stat(gw.Root); err != nil || !fi.IsDir() {
+				return fmt.Errorf("gateway.root %q is not a directory", gw.Root)
+			}
+		}
+		if r.Kind == ROUTE_FASTCGI && gw.Address == "" {
+			return fmt.Errorf("missing gateway.address")
+		}
+		if gw.Timeout <= 0 {
+			gw.Timeout = 30 * time.Second
+		}
+		if gw.Root != "" {
+			r.Wildcard = true
+		}
+	default:
+		return fmt.Errorf("unknown route kind %q", r.Kind)
+	}
+	return nil
+}
+
+func checkRule(r *Rule) error {
+	if (r.Match == "") == (r.Prefix == "") {
+		return fmt.Errorf("exactly one of match and prefix is required")
+	}
+	if r.Match != "" {
+		if _, err := regexp.Compile(r.Match); err != nil {
+			return fmt.Errorf("invalid match: %w", err)
+		}
+	}
+	if r.Prefix != "" && !strings.HasPrefix(r.Prefix, "/") {
+		return fmt.Errorf("prefix %q must be absolute", r.Prefix)
+	}
+
+	switch r.Action {
+	case RULE_REWRITE:
+		if !strings.HasPrefix(r.Target, "/") {
+			return fmt.Errorf("rewrite target %q must be an absolute path", r.Target)
+		}
+	case RULE_REDIRECT:
+		if r.Target == "" {
+			return fmt.Errorf("missing redirect target")
+		}
+		if r.Status == 0 {
+			r.Status = 302
+		}
+		switch r.Status {
+		case 301, 302, 307, 308:
+		default:
+			return fmt.Errorf("invalid redirect status %d", r.Status)
+		}
+	case RULE_RESPOND:
+		if r.Status == 0 {
+			r.Status = 200
+		}
+		if r.Status < 100 || r.Status > 599 {
+			return fmt.Errorf("invalid status %d", r.Status)
+		}
+		if r.ContentType == "" {
+			r.ContentType = "text/plain; charset=utf-8"
+		}
+	case RULE_HEADERS:
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+func checkUpstream(up *Upstream) error {
+	if len(up.Servers) == 0 {
+		return fmt.Errorf("no servers")
+	}
+
+	switch up.Balance {
+	case "":
+		up.Balance = "round_robin"
+	case "round_robin", "least_conn":
+	case "consistent_hash":
+		if up.HashKey == "" {
+			up.HashKey = "ip"
+		}
+		if up.HashKey != "ip" && up.HashKey != "path" &&
+			!strings.HasPrefix(up.HashKey, "header:") && !strings.HasPrefix(up.HashKey, "query:") {
+			return fmt.Errorf("invalid hash_key %q", up.HashKey)
+		}
+	default:
+		return fmt.Errorf("invalid balance %q", up.Balance)
+	}
+
+	if up.Timeout <= 0 {
+		up.Timeout = 10 * time.Second
+	}
+	if up.Retries < 0 {
+		return fmt.Errorf("negative retries")
+	}
+	if up.MaxConns <= 0 {
+		up.MaxConns = 512
+	}
+	if up.MaxFails <= 0 {
+		up.MaxFails = 3
+	}
+	if up.Cooldown <= 0 {
+		up.Cooldown = 10 * time.Second
+	}
+
+	hc := &up.HealthCheck
+	if hc.Path != "" {
+		if !strings.HasPrefix(hc.Path, "/") {
+			return fmt.Errorf("health_check.path %q must be absolute", hc.Path)
+		}
+		if hc.Status == 0 {
+			hc.Status = 200
+		}
+		if hc.Interval <= 0 {
+			hc.Interval = 5 * time.Second
+		}
+		if hc.Timeout <= 0 {
+			hc.Timeout = 2 * time.Second
+		}
+	}
+	return nil
+}
+
+/* `/` and the empty string both mean "not mounted" */
+func cleanMount(mount string) (string, bool) {
+	if mount == "" {
+		return "", true
+	}
+	if !strings.HasPrefix(mount, "/") || strings.Contains(mount, "*") {
+		return "", false
+	}
+
+	mount = path.Clean(mount)
+	if mount == "/" {
+		return "", true
+	}
+	return mount, true
+}
+
+/* Also fills in the default seccomp mode */
+func checkSandbox(sb *Sandbox) error {
+	switch sb.Seccomp {
+	case "":
+		sb.Seccomp = SECCOMP_ERRNO
+	case SECCOMP_ERRNO, SECCOMP_KILL, SECCOMP_OFF:
+	default:
+		return fmt.Errorf("unknown seccomp mode %q, expected %q, %q or %q", sb.Seccomp, SECCOMP_ERRNO, SECCOMP_KILL, SECCOMP_OFF)
+	}
+
+	for _, p := range append(append([]string(nil), sb.ReadPaths...), sb.WritePaths...) {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("sandbox path %q must be absolute", p)
+		}
+	}
+	if sb.MaxCPU < 0 {
+		return fmt.Errorf("max_cpu must not be negative")
+	}
+	for _, name := range sb.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+func checkAdmin(a *Admin) error {
+	if a.Listen == "" {
+		return nil
+	}
+
+	if a.TokenFile != "" {
+		b, err := os.ReadFile(a.TokenFile)
+		if err != nil {
+			return fmt.Errorf("token_file: %w", err)
+		}
+		a.Token = strings.TrimSpace(string(b))
+	}
+	if a.Token == "" {
+		return fmt.Errorf("listen %q needs a token or token_file", a.Listen)
+	}
+	return nil
+}
+
+func checkAccessLog(a *AccessLog) error {
+	switch a.Format {
+	case ACCESS_COMMON, ACCESS_COMBINED, ACCESS_JSON:
+	default:
+		return fmt.Errorf("unknown format %q", a.Format)
+	}
+
+	for _, f := range a.Fields {
+		switch f {
+		case FIELD_LATENCY, FIELD_BYTES, FIELD_ROUTE, FIELD_MODULE, FIELD_REQUEST_ID, FIELD_CLIENT_IP:
+		default:
+			return fmt.Errorf("unknown field %q", f)
+		}
+	}
+
+	if a.SampleRate <= 0 || a.SampleRate > 1 {
+		return fmt.Errorf("sample_rate %v must be in (0, 1]", a.SampleRate)
+	}
+	return nil
+}
+
+/* Values not present in the file keep these */
+func defaultConfig() Config {
+	return Config{
+		Log: Log{
+			Level:  "debug",
+			Buffer: 1000,
+		},
+		AccessLog: AccessLog{
+			Format:     ACCESS_COMBINED,
+			Fields:     []string{FIELD_LATENCY, FIELD_BYTES, FIELD_ROUTE, FIELD_MODULE, FIELD_REQUEST_ID, FIELD_CLIENT_IP},
+			SampleRate: 1,
+			MaxSizeMB:  64,
+			MaxBackups: 5,
+			MaxAgeDays: 30,
+			Compress:   true,
+		},
+		Router: Router{
+			Paths: Paths{
+				Decode:          true,
+				CollapseSlashes: true,
+				RejectControl:   true,
+				EncodedSlash:    "reject",
+				DotSegments:     "resolve",
+			},
+		},
+	}
+}
@@ -1,10 +1,300 @@
 package config
 
+import "time"
+
 type Config struct {
-	Modules Modules
+	Modules   Modules
+	Router    Router
+	Module    map[string]ModuleConf `toml:"module"`
+	Routes    []Route               `toml:"routes"`
+	Upstreams map[string]Upstream   `toml:"upstreams"`
+	Rules     []Rule                `toml:"rules"`
+	Admin     Admin                 `toml:"admin"`
+	Tracing   Tracing               `toml:"tracing"`
+	AccessLog AccessLog             `toml:"access_log"`
+	Log       Log                   `toml:"log"`
 }
 
 type Modules struct {
-	Path string
+	Path      string
 	Mirrorlib string
 }
+
+/*
+ * Per module settings, keyed by the module filename without extension.
+ * Routes are registered under Mount, capability scopes apply to the
+ * resulting full path. The router is not ready while a Required module is
+ * not loaded or reports itself unhealthy.
+ */
+type ModuleConf struct {
+	Capabilities []string `toml:"capabilities"`
+	Mount        string   `toml:"mount"`
+	Required     bool     `toml:"required"`
+	Sandbox      Sandbox  `toml:"sandbox"`
+}
+
+const (
+	SECCOMP_ERRNO = "errno"
+	SECCOMP_KILL  = "kill"
+	SECCOMP_OFF   = "off"
+)
+
+/*
+ * Confinement of an out-of-process (`.proc`) module worker, Linux only.
+ * Seccomp denies a default set of privileged syscalls plus DenySyscalls,
+ * failing them with EPERM or killing the worker. Landlock limits the file
+ * system to ReadPaths / WritePaths (the worker's own executable is always
+ * readable), and a zero limit leaves that rlimit alone. The worker's
+ * environment holds only PATH, the variables it needs to reach the router
+ * and the ones named in Env, if the router has them.
+ */
+type Sandbox struct {
+	Enabled          bool          `toml:"enabled"`
+	Seccomp          string        `toml:"seccomp"`
+	DenySyscalls     []string      `toml:"deny_syscalls"`
+	ReadPaths        []string      `toml:"read_paths"`
+	WritePaths       []string      `toml:"write_paths"`
+	MaxMemory        uint64        `toml:"max_memory"`
+	MaxCPU           time.Duration `toml:"max_cpu"`
+	MaxFiles         uint64        `toml:"max_files"`
+	UserNamespace    bool          `toml:"user_namespace"`
+	NetworkNamespace bool          `toml:"network_namespace"`
+	Env              []string      `toml:"env"`
+}
+
+const (
+	ROUTE_MODULE  = "module"
+	ROUTE_PROXY   = "proxy"
+	ROUTE_STATIC  = "static"
+	ROUTE_CGI     = "cgi"
+	ROUTE_FASTCGI = "fastcgi"
+)
+
+/*
+ * Config-defined route. No Methods means any method.
+ *
+ *   module: registered on behalf of Module, Handler is the name of an
+ *           exported or_http_handler_t symbol in it
+ *   proxy:  forwarded to the servers of Upstream, StripPrefix drops Path
+ *           from the forwarded request path
+ *   static: files under Root served below Path (always a wildcard route),
+ *           GET and HEAD only unless Methods says otherwise
+ *   cgi:     script executed per request, see Gateway
+ *   fastcgi: request sent to a FastCGI server, see Gateway
+ */
+type Route struct {
+	Kind     string   `toml:"kind"`
+	Path     string   `toml:"path"`
+	Methods  []string `toml:"methods"`
+	Wildcard bool     `toml:"wildcard"`
+
+	Module  string `toml:"module"`
+	Handler string `toml:"handler"`
+
+	Upstream    string `toml:"upstream"`
+	StripPrefix bool   `toml:"strip_prefix"`
+
+	Static  Static  `toml:"static"`
+	Gateway Gateway `toml:"gateway"`
+}
+
+/*
+ * CGI/FastCGI backend. Either Script is the one script serving the whole
+ * route, or the script is looked up below Root from the request path (the
+ * rest becomes PATH_INFO). For fastcgi, Script/Root are only used for
+ * SCRIPT_FILENAME and Address is `unix:/path/to.sock` or `host:port`.
+ * Env is added to the standard CGI/1.1 variables.
+ */
+type Gateway struct {
+	Script  string            `toml:"script"`
+	Root    string            `toml:"root"`
+	Address string            `toml:"address"`
+	Timeout time.Duration     `toml:"timeout"`
+	Env     map[string]string `toml:"env"`
+}
+
+/*
+ * Static file serving. Compress serves a pre-compressed `.gz`/`.br` sibling
+ * when present and not older than the file, and compresses in memory
+ * otherwise. CacheControl rules are
+ * checked in order, the first match sets the Cache-Control header.
+ */
+type Static struct {
+	Root         string      `toml:"root"`
+	Index        []string    `toml:"index"`
+	Listing      bool        `toml:"listing"`
+	ByteRanges   bool        `toml:"byte_ranges"`
+	Compress     bool        `toml:"compress"`
+	CacheControl []CacheRule `toml:"cache_control"`
+}
+
+/* Match is a path.Match pattern, on the file name unless it contains a `/` */
+type CacheRule struct {
+	Match string `toml:"match"`
+	Value string `toml:"value"`
+}
+
+/* Path as understood by the router, wildcard routes end in `/*` */
+func (r Route) Pattern() string {
+	if r.Wildcard {
+		return r.Path + "/*"
+	}
+	return r.Path
+}
+
+/*
+ * Upstream server pool for proxy routes. Balance is one of round_robin,
+ * least_conn or consistent_hash; HashKey (consistent_hash only) is `ip`,
+ * `path`, `header:<name>` or `query:<name>`. Timeout and Retries apply per
+ * attempt and per request.
+ *
+ * A server is ejected after MaxFails consecutive errors, timeouts or 5xx
+ * responses and gets a single trial request once Cooldown has passed.
+ */
+type Upstream struct {
+	Servers     []string      `toml:"servers"`
+	Balance     string        `toml:"balance"`
+	HashKey     string        `toml:"hash_key"`
+	Timeout     time.Duration `toml:"timeout"`
+	Retries     int           `toml:"retries"`
+	MaxConns    int           `toml:"max_conns"`
+	MaxFails    int           `toml:"max_fails"`
+	Cooldown    time.Duration `toml:"cooldown"`
+	HealthCheck HealthCheck   `toml:"health_check"`
+}
+
+/* Active probing, disabled without a Path */
+type HealthCheck struct {
+	Path     string        `toml:"path"`
+	Status   int           `toml:"status"`
+	Interval time.Duration `toml:"interval"`
+	Timeout  time.Duration `toml:"timeout"`
+}
+
+const (
+	RULE_REWRITE  = "rewrite"
+	RULE_REDIRECT = "redirect"
+	RULE_RESPOND  = "respond"
+	RULE_HEADERS  = "headers"
+)
+
+/*
+ * Rule evaluated in order before route lookup. Match is a regular expression
+ * and Prefix a plain path prefix, exactly one of them has to be set. Target
+ * may use $1/${name} captures of Match; with Prefix the rest of the path is
+ * appended to it instead.
+ *
+ *   rewrite:  route the request as Target instead (a `?` replaces the query)
+ *   redirect: answer Status (301/302/307/308) with Location Target
+ *   respond:  answer Status with Body and ContentType
+ *   headers:  only apply the header operations
+ *
+ * Header operations work with every action. Redirect and respond always end
+ * evaluation, rewrite and headers only when Stop is set.
+ */
+type Rule struct {
+	Match  string `toml:"match"`
+	Prefix string `toml:"prefix"`
+	Action string `toml:"action"`
+	Target string `toml:"target"`
+	Stop   bool   `toml:"stop"`
+
+	Status      int    `toml:"status"`
+	Body        string `toml:"body"`
+	ContentType string `toml:"content_type"`
+
+	SetHeaders           map[string]string `toml:"set_headers"`
+	RemoveHeaders        []string          `toml:"remove_headers"`
+	SetRequestHeaders    map[string]string `toml:"set_request_headers"`
+	RemoveRequestHeaders []string          `toml:"remove_request_headers"`
+}
+
+/*
+ * Admin API on its own listener, disabled without Listen. Every request needs
+ * `Authorization: Bearer <token>`, the token is read from TokenFile if set so
+ * it can stay out of the config. Pprof and Debug enable the /debug endpoints,
+ * both off by default as profiles and dumps expose internals.
+ */
+type Admin struct {
+	Listen    string `toml:"listen"`
+	Token     string `toml:"token"`
+	TokenFile string `toml:"token_file"`
+	Pprof     bool   `toml:"pprof"` /* /debug/pprof/... */
+	Debug     bool   `toml:"debug"` /* /debug/goroutines, /debug/gc, /debug/cgo */
+}
+
+/*
+ * Request tracing. Every request gets a request ID and W3C trace context
+ * either way; with File set, spans of sampled requests are also appended to
+ * it as OTLP/JSON lines. Service is the service.name of the exported spans
+ * (default "omnirouter").
+ */
+type Tracing struct {
+	File    string `toml:"file"`
+	Service string `toml:"service"`
+}
+
+/*
+ * Application log. Level is the global level (trace, debug, info, warn, error
+ * or fatal), Buffer the number of recent entries kept in memory for the admin
+ * API (0 disables it). Both levels can be changed at runtime.
+ */
+type Log struct {
+	Level  string `toml:"level"`
+	Buffer int    `toml:"buffer"`
+}
+
+/*
+ * Access log, one line per request, disabled without File. Format is
+ * "common", "combined" (the default) or "json"; Fields picks the extras
+ * logged (all by default, latency is in seconds). In the text formats
+ * client_ip and bytes fill the standard columns and the others are appended
+ * as key=value. SampleRate (0, 1] logs that fraction of the requests. The
+ * file is rotated on its own, like the application log.
+ */
+type AccessLog struct {
+	File       string   `toml:"file"`
+	Format     string   `toml:"format"`
+	Fields     []string `toml:"fields"`
+	SampleRate float64  `toml:"sample_rate"`
+	MaxSizeMB  int      `toml:"max_size_mb"`
+	MaxBackups int      `toml:"max_backups"`
+	MaxAgeDays int      `toml:"max_age_days"`
+	Compress   bool     `toml:"compress"`
+}
+
+const (
+	ACCESS_COMMON   = "common"
+	ACCESS_COMBINED = "combined"
+	ACCESS_JSON     = "json"
+)
+
+/* Selectable access log fields */
+const (
+	FIELD_LATENCY    = "latency"
+	FIELD_BYTES      = "bytes"
+	FIELD_ROUTE      = "route"
+	FIELD_MODULE     = "module"
+	FIELD_REQUEST_ID = "request_id"
+	FIELD_CLIENT_IP  = "client_ip"
+)
+
+/*
+ * UpstreamStatus is the path of the upstream health JSON, Metrics the one of
+ * the Prometheus metrics, empty (the default) disables either. Both are
+ * public on the main listener; the admin listener serves /metrics behind its
+ * token regardless.
+ */
+type Router struct {
+	Paths          Paths
+	UpstreamStatus string `toml:"upstream_status"`
+	Metrics        string `toml:"metrics"`
+}
+
+type Paths struct {
+	Decode          bool   `toml:"decode"`
+	CollapseSlashes bool   `toml:"collapse_slashes"`
+	RejectControl   bool   `toml:"reject_control"`
+	EncodedSlash    string `toml:"encoded_slash"`
+	DotSegments     string `toml:"dot_segments"`
+}
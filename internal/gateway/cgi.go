@@ -0,0 +1,40 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"omnirouter/internal/logger"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* Runs a CGI script once, body on stdin, returns everything it wrote to stdout */
+func runCGI(script string, env []string, body []byte, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Dir = filepath.Dir(script)
+	cmd.Env = append(env, "PATH="+os.Getenv("PATH"))
+	cmd.Stdin = bytes.NewReader(body)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		logger.Warn("CGI script wrote to stderr", "script", script, "stderr", strings.TrimSpace(stderr.String()))
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, errTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
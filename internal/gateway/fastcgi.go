@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"omnirouter/internal/logger"
+	"os"
+	"strings"
+	"time"
+)
+
+/* FastCGI 1.0 record types, only the ones a responder client needs */
+const (
+	FCGI_BEGIN_REQUEST uint8 = 1
+	FCGI_END_REQUEST   uint8 = 3
+	FCGI_PARAMS        uint8 = 4
+	FCGI_STDIN         uint8 = 5
+	FCGI_STDOUT        uint8 = 6
+	FCGI_STDERR        uint8 = 7
+)
+
+const (
+	fcgiVersion   = 1
+	fcgiResponder = 1
+	fcgiRequestID = 1
+	fcgiMaxRecord = 65535
+)
+
+/*
+ * One request per connection: BEGIN_REQUEST, the environment as PARAMS, the
+ * body as STDIN, then STDOUT is collected until END_REQUEST. Address is
+ * `unix:/path/to.sock` or `host:port`.
+ */
+func runFastCGI(address string, env []string, body []byte, timeout time.Duration) ([]byte, error) {
+	network := "tcp"
+	if sock, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, address = "unix", sock
+	}
+
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, timeoutErr(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	w := bufio.NewWriter(conn)
+	begin := []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(w, FCGI_BEGIN_REQUEST, begin); err != nil {
+		return nil, timeoutErr(err)
+	}
+	if err := writeStream(w, FCGI_PARAMS, encodeParams(env)); err != nil {
+		return nil, timeoutErr(err)
+	}
+	if err := writeStream(w, FCGI_STDIN, body); err != nil {
+		return nil, timeoutErr(err)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, timeoutErr(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	r := bufio.NewReader(conn)
+	for {
+		typ, content, err := readRecord(r)
+		if err != nil {
+			return nil, timeoutErr(err)
+		}
+		switch typ {
+		case FCGI_STDOUT:
+			stdout.Write(content)
+		case FCGI_STDERR:
+			stderr.Write(content)
+		case FCGI_END_REQUEST:
+			if stderr.Len() > 0 {
+				logger.Warn("FastCGI server wrote to stderr", "address", address, "stderr", strings.TrimSpace(stderr.String()))
+			}
+			if len(content) >= 5 && content[4] != 0 {
+				return nil, fmt.Errorf("fastcgi request rejected, protocol status %d", content[4])
+			}
+			return stdout.Bytes(), nil
+		}
+	}
+}
+
+func timeoutErr(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: %v", errTimeout, err)
+	}
+	return err
+}
+
+func writeRecord(w io.Writer, typ uint8, content []byte) error {
+	padding := (8 - len(content)%8) % 8
+	hdr := [8]byte{fcgiVersion, typ, 0, fcgiRequestID, 0, 0, uint8(padding), 0}
+	binary.BigEndian.PutUint16(hdr[4:6], uint16(len(content)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, padding))
+	return err
+}
+
+/* Splits content into records and terminates the stream with an empty one */
+func writeStream(w io.Writer, typ uint8, content []byte) error {
+	for len(content) > 0 {
+		n := min(len(content), fcgiMaxRecord)
+		if err := writeRecord(w, typ, content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return writeRecord(w, typ, nil)
+}
+
+func readRecord(r io.Reader) (uint8, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	if hdr[0] != fcgiVersion {
+		return 0, nil, fmt.Errorf("unsupported fastcgi version %d", hdr[0])
+	}
+	n := int(binary.BigEndian.Uint16(hdr[4:6]))
+	buf := make([]byte, n+int(hdr[6]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	return hdr[1], buf[:n], nil
+}
+
+/* Name-value pairs, lengths over 127 take four bytes with the top bit set */
+func encodeParams(env []string) []byte {
+	var buf bytes.Buffer
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		writeParamLen(&buf, len(k))
+		writeParamLen(&buf, len(v))
+		buf.WriteString(k)
+		buf.WriteString(v)
+	}
+	return buf.Bytes()
+}
+
+func writeParamLen(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(uint8(n))
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31)
+	buf.Write(b[:])
+}
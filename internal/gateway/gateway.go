@@ -0,0 +1,230 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/router"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errTimeout = errors.New("gateway timeout")
+
+type handler struct {
+	kind   string
+	prefix string
+	gw     config.Gateway
+}
+
+var _ router.HTTPHandler = (*handler)(nil)
+
+/* Registers every `cgi` and `fastcgi` kind route */
+func Setup(conf *config.Config) {
+	for _, r := range conf.Routes {
+		if r.Kind != config.ROUTE_CGI && r.Kind != config.ROUTE_FASTCGI {
+			continue
+		}
+
+		mask, err := router.ParseMethods(r.Methods)
+		if err != nil {
+			logger.Error("Invalid methods in route config", "path", r.Path, "kind", r.Kind, "err", err)
+			continue
+		}
+
+		h := &handler{kind: r.Kind, prefix: r.Path, gw: r.Gateway}
+		router.GetHTTPRouter().Register(capabilities.Unrestricted(), mask, r.Pattern(), h)
+	}
+}
+
+func (h *handler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+
+	script, scriptName, pathInfo, ok := h.resolve(router.StripMount(h.prefix, router.RequestPath(ctx)))
+	if !ok {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	env := h.environ(ctx, script, scriptName, pathInfo)
+
+	var out []byte
+	var err error
+	if h.kind == config.ROUTE_CGI {
+		out, err = runCGI(script, env, ctx.Request.Body(), h.gw.Timeout)
+	} else {
+		out, err = runFastCGI(h.gw.Address, env, ctx.Request.Body(), h.gw.Timeout)
+	}
+
+	if err != nil {
+		logger.ErrorContext(ctx, "Gateway request failed", "kind", h.kind, "script", script, "err", err.Error())
+		if errors.Is(err, errTimeout) {
+			ctx.SetStatusCode(fasthttp.StatusGatewayTimeout)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		}
+		return
+	}
+
+	if err := writeResponse(ctx, out); err != nil {
+		logger.ErrorContext(ctx, "Malformed gateway response", "kind", h.kind, "script", script, "err", err.Error())
+		ctx.ResetBody()
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+	}
+}
+
+/*
+ * Splits the mount-relative path into the script and PATH_INFO. With Root, the
+ * shortest prefix naming a regular file below it is the script; a FastCGI
+ * server may not share our filesystem, so there the whole path is used if
+ * nothing matches locally.
+ */
+func (h *handler) resolve(rel string) (string, string, string, bool) {
+	if h.gw.Script != "" {
+		if rel == "/" {
+			rel = ""
+		}
+		return h.gw.Script, h.prefix, rel, true
+	}
+
+	segments := strings.Split(strings.TrimPrefix(rel, "/"), "/")
+	for i := range segments {
+		candidate := "/" + strings.Join(segments[:i+1], "/")
+		fi, err := os.Stat(filepath.Join(h.gw.Root, filepath.FromSlash(candidate)))
+		if err != nil {
+			break
+		}
+		if fi.Mode().IsRegular() {
+			return filepath.Join(h.gw.Root, filepath.FromSlash(candidate)),
+				path.Join(h.prefix, candidate), strings.TrimPrefix(rel, candidate), true
+		}
+	}
+
+	if h.kind == config.ROUTE_FASTCGI && rel != "/" {
+		return filepath.Join(h.gw.Root, filepath.FromSlash(rel)), path.Join(h.prefix, rel), "", true
+	}
+	return "", "", "", false
+}
+
+/* CGI/1.1 meta-variables (RFC 3875) plus the configured extras */
+func (h *handler) environ(ctx *fasthttp.RequestCtx, script, scriptName, pathInfo string) []string {
+	host, port, err := net.SplitHostPort(ctx.LocalAddr().String())
+	if err != nil {
+		host = ctx.LocalAddr().String()
+	}
+	remoteHost, remotePort, err := net.SplitHostPort(ctx.RemoteAddr().String())
+	if err != nil {
+		remoteHost = ctx.RemoteAddr().String()
+	}
+	if name := ctx.Host(); len(name) > 0 {
+		if n, _, err := net.SplitHostPort(string(name)); err == nil {
+			host = n
+		} else {
+			host = string(name)
+		}
+	}
+
+	env := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "OmniRouter",
+		"SERVER_PROTOCOL":   string(ctx.Request.Header.Protocol()),
+		"SERVER_NAME":       host,
+		"SERVER_PORT":       port,
+		"REQUEST_METHOD":    string(ctx.Method()),
+		"REQUEST_URI":       string(ctx.RequestURI()),
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   script,
+		"PATH_INFO":         pathInfo,
+		"QUERY_STRING":      string(ctx.URI().QueryString()),
+		"REMOTE_ADDR":       remoteHost,
+		"REMOTE_PORT":       remotePort,
+		"CONTENT_TYPE":      string(ctx.Request.Header.ContentType()),
+		"CONTENT_LENGTH":    strconv.Itoa(len(ctx.Request.Body())),
+	}
+	if h.gw.Root != "" {
+		env["DOCUMENT_ROOT"] = h.gw.Root
+	}
+	if pathInfo != "" && h.gw.Root != "" {
+		env["PATH_TRANSLATED"] = filepath.Join(h.gw.Root, filepath.FromSlash(pathInfo))
+	}
+	if ctx.IsTLS() {
+		env["HTTPS"] = "on"
+	}
+
+	for k, v := range ctx.Request.Header.All() {
+		name := strings.ToUpper(strings.ReplaceAll(string(k), "-", "_"))
+		switch name {
+		case "CONTENT_TYPE", "CONTENT_LENGTH":
+			continue
+		case "PROXY":
+			/* httpoxy, HTTP_PROXY must never come from the client */
+			continue
+		}
+		key := "HTTP_" + name
+		if prev, ok := env[key]; ok {
+			env[key] = prev + ", " + string(v)
+		} else {
+			env[key] = string(v)
+		}
+	}
+
+	for k, v := range h.gw.Env {
+		env[k] = v
+	}
+
+	out := make([]string, 0, len(env))
+	for k, v := range env {
+		out = append(out, k+"="+v)
+	}
+	return out
+}
+
+/* Parses the CGI response: header block, blank line, body */
+func writeResponse(ctx *fasthttp.RequestCtx, out []byte) error {
+	br := bufio.NewReader(bytes.NewReader(out))
+	hdr, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil && len(hdr) == 0 {
+		return err
+	}
+
+	status := fasthttp.StatusOK
+	if s := hdr.Get("Status"); s != "" {
+		code, _, _ := strings.Cut(s, " ")
+		if status, err = strconv.Atoi(code); err != nil {
+			return err
+		}
+		if status < 100 || status > 999 {
+			return fmt.Errorf("invalid status %q", s)
+		}
+	} else if hdr.Get("Location") != "" {
+		status = fasthttp.StatusFound
+	}
+
+	for k, vs := range hdr {
+		if k == "Status" {
+			continue
+		}
+		for _, v := range vs {
+			ctx.Response.Header.Add(k, v)
+		}
+	}
+
+	/* Whatever follows the blank line the header parser stopped at */
+	body, _ := io.ReadAll(br)
+
+	ctx.SetStatusCode(status)
+	ctx.SetBody(body)
+	return nil
+}
@@ -0,0 +1,110 @@
+package health
+
+import (
+	"encoding/json"
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/config"
+	"omnirouter/internal/modmgr"
+	"omnirouter/internal/router"
+	"sort"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	HEALTHZ_PATH = "/healthz"
+	READYZ_PATH  = "/readyz"
+)
+
+var (
+	started  atomic.Bool
+	required []string
+)
+
+/* A required module that keeps the router from being ready */
+type Check struct {
+	Module string `json:"module"`
+	State  string `json:"state"`
+	Health string `json:"health,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type Readiness struct {
+	Ready   bool    `json:"ready"`
+	Started bool    `json:"started"`
+	Failing []Check `json:"failing,omitempty"`
+}
+
+type healthzHandler struct{}
+type readyzHandler struct{}
+
+/* Registers the liveness and readiness probes */
+func Setup(conf *config.Config) {
+	for name, mc := range conf.Module {
+		if mc.Required {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+
+	mask := router.METHOD_GET | router.METHOD_HEAD
+	router.GetHTTPRouter().Register(capabilities.Unrestricted(), mask, HEALTHZ_PATH, healthzHandler{})
+	router.GetHTTPRouter().Register(capabilities.Unrestricted(), mask, READYZ_PATH, readyzHandler{})
+}
+
+/* Startup module loading is done, until then the router is not ready */
+func MarkStarted() {
+	started.Store(true)
+}
+
+/*
+ * Ready once startup is done and every required module is loaded and does
+ * not report itself unhealthy (degraded is still ready).
+ */
+func Ready() Readiness {
+	r := Readiness{Started: started.Load()}
+
+	loaded := make(map[string]modmgr.ModuleInfo)
+	for _, info := range modmgr.List() {
+		if prev, ok := loaded[info.Name]; !ok || prev.State != modmgr.MODSTATE_LOADED.String() {
+			loaded[info.Name] = info
+		}
+	}
+
+	for _, name := range required {
+		info, ok := loaded[name]
+		switch {
+		case !ok:
+			r.Failing = append(r.Failing, Check{Module: name, State: "missing"})
+		case info.State != modmgr.MODSTATE_LOADED.String():
+			r.Failing = append(r.Failing, Check{Module: name, State: info.State})
+		case info.Health == modmgr.HEALTH_UNHEALTHY.String():
+			r.Failing = append(r.Failing, Check{Module: name, State: info.State, Health: info.Health, Detail: info.HealthDetail})
+		}
+	}
+
+	r.Ready = r.Started && len(r.Failing) == 0
+	return r
+}
+
+func (healthzHandler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString("ok\n")
+}
+
+func (readyzHandler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+	r := Ready()
+	body, err := json.Marshal(r)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	if !r.Ready {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
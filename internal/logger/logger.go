@@ -112,10 +112,11 @@ func Setup() {
 		console = cw
 	}
 
-	multi := zerolog.MultiLevelWriter(console, fileWriter)
+	multi := zerolog.MultiLevelWriter(console, fileWriter, ring)
 
 	zerolog.TimeFieldFormat = defaultTimeFormat
-	zerolog.SetGlobalLevel(defaultLevel)
+	/* Levels are checked by emit, per module levels may be below the global one */
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
 
 	zerolog.CallerMarshalFunc = func(_ uintptr, file string, line int) string {
 		if IsLogCallerModuleSet() {
@@ -128,8 +129,8 @@ func Setup() {
 		return fmt.Sprintf("%s:%d", file, line)
 	}
 
-	/* Increase frame skips due to the wrapper we have */
-	zerolog.CallerSkipFrameCount = 3
+	/* Increase frame skips due to the wrappers we have (level func + emit) */
+	zerolog.CallerSkipFrameCount = 4
 	log.Logger = zerolog.New(multi).
 		With().
 		Timestamp().
@@ -137,32 +138,80 @@ func Setup() {
 		Logger()
 }
 
-func SetLevel(level zerolog.Level) { defaultLevel = level; zerolog.SetGlobalLevel(level) }
-
 func With(fields map[string]any) zerolog.Logger {
 	return log.Logger.With().Fields(fields).Logger()
 }
 
-func Debug(msg string, kv ...any) { addKV(log.Debug(), kv...).Msg(msg) }
-func Info(msg string, kv ...any)  { addKV(log.Info(), kv...).Msg(msg) }
-func Warn(msg string, kv ...any)  { addKV(log.Warn(), kv...).Msg(msg) }
-func Error(msg string, kv ...any) { addKV(log.Error(), kv...).Msg(msg) }
-func Fatal(msg string, kv ...any) { addKV(log.Fatal(), kv...).Msg(msg) }
+func Debug(msg string, kv ...any) { emit(&log.Logger, "", zerolog.DebugLevel, msg, kv) }
+func Info(msg string, kv ...any)  { emit(&log.Logger, "", zerolog.InfoLevel, msg, kv) }
+func Warn(msg string, kv ...any)  { emit(&log.Logger, "", zerolog.WarnLevel, msg, kv) }
+func Error(msg string, kv ...any) { emit(&log.Logger, "", zerolog.ErrorLevel, msg, kv) }
+func Fatal(msg string, kv ...any) { emit(&log.Logger, "", zerolog.FatalLevel, msg, kv) }
+
+/* Field carrying the request ID on every log line made while handling a request */
+const REQUEST_ID_KEY = "request_id"
+
+type ctxLoggerKey struct{}
+
+/* Implemented by *fasthttp.RequestCtx, whose user values are its context values */
+type ValueSetter interface {
+	SetUserValue(key, value any)
+}
+
+/* Binds a logger with the given fields to ctx, used by the *Context variants */
+func Bind(ctx ValueSetter, kv ...any) {
+	l := addCtxKV(log.Logger.With(), kv...).Logger()
+	ctx.SetUserValue(ctxLoggerKey{}, &l)
+}
+
+func fromContext(ctx context.Context) *zerolog.Logger {
+	if l, ok := ctx.Value(ctxLoggerKey{}).(*zerolog.Logger); ok {
+		return l
+	}
+	return &log.Logger
+}
 
 func DebugContext(ctx context.Context, msg string, kv ...any) {
-	addKV(log.Ctx(ctx).Debug(), kv...).Msg(msg)
+	emit(fromContext(ctx), "", zerolog.DebugLevel, msg, kv)
 }
 func InfoContext(ctx context.Context, msg string, kv ...any) {
-	addKV(log.Ctx(ctx).Info(), kv...).Msg(msg)
+	emit(fromContext(ctx), "", zerolog.InfoLevel, msg, kv)
 }
 func WarnContext(ctx context.Context, msg string, kv ...any) {
-	addKV(log.Ctx(ctx).Warn(), kv...).Msg(msg)
+	emit(fromContext(ctx), "", zerolog.WarnLevel, msg, kv)
 }
 func ErrorContext(ctx context.Context, msg string, kv ...any) {
-	addKV(log.Ctx(ctx).Error(), kv...).Msg(msg)
+	emit(fromContext(ctx), "", zerolog.ErrorLevel, msg, kv)
 }
 func FatalContext(ctx context.Context, msg string, kv ...any) {
-	addKV(log.Ctx(ctx).Fatal(), kv...).Msg(msg)
+	emit(fromContext(ctx), "", zerolog.FatalLevel, msg, kv)
+}
+
+/*
+ * Logs on behalf of a module: its level override applies and the entry gets
+ * a module field. caller is shown in place of the Go file:line.
+ */
+func Module(module, caller string, level zerolog.Level, msg string, kv ...any) {
+	SetLogCallerModule(caller)
+	emit(&log.Logger, module, level, msg, kv)
+}
+
+func emit(l *zerolog.Logger, module string, level zerolog.Level, msg string, kv []any) {
+	if !moduleEnabled(module, level) {
+		ConsumeLogCallerModule()
+		return
+	}
+
+	var e *zerolog.Event
+	if level == zerolog.FatalLevel {
+		e = l.Fatal()
+	} else {
+		e = l.WithLevel(level)
+	}
+	if module != "" {
+		e = e.Str(MODULE_KEY, module)
+	}
+	addKV(e, kv...).Msg(msg)
 }
 
 func addKV(e *zerolog.Event, kv ...any) *zerolog.Event {
@@ -178,3 +227,12 @@ func addKV(e *zerolog.Event, kv ...any) *zerolog.Event {
 	}
 	return e
 }
+
+func addCtxKV(c zerolog.Context, kv ...any) zerolog.Context {
+	for i := 0; i+1 < len(kv); i += 2 {
+		if k, ok := kv[i].(string); ok {
+			c = c.Interface(k, kv[i+1])
+		}
+	}
+	return c
+}
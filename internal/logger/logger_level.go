@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+/* Field naming the module an entry was logged by (or is about) */
+const MODULE_KEY = "module"
+
+/*
+ * The global level applies to everything, a module level overrides it for
+ * what the module logs itself. Both can be changed at runtime through the
+ * admin API, SIGUSR1 toggles the global level between verbose and normal.
+ */
+var (
+	globalLevel  atomic.Int32
+	moduleLevels atomic.Pointer[map[string]zerolog.Level]
+
+	levelMu      sync.Mutex
+	toggledLevel *zerolog.Level /* global level to restore on the next toggle */
+)
+
+func init() {
+	globalLevel.Store(int32(defaultLevel))
+}
+
+/* Only the levels that can be logged at, "" is not a level */
+func ParseLevel(s string) (zerolog.Level, error) {
+	l, err := zerolog.ParseLevel(s)
+	if err != nil {
+		return l, err
+	}
+	if l < zerolog.TraceLevel || l > zerolog.FatalLevel {
+		return l, fmt.Errorf("unknown level %q", s)
+	}
+	return l, nil
+}
+
+func Level() zerolog.Level { return zerolog.Level(globalLevel.Load()) }
+
+func SetLevel(level zerolog.Level) {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+	toggledLevel = nil
+	globalLevel.Store(int32(level))
+}
+
+func SetModuleLevel(module string, level zerolog.Level) {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+	next := ModuleLevels()
+	next[module] = level
+	moduleLevels.Store(&next)
+}
+
+/* Back to the global level */
+func ClearModuleLevel(module string) {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+	next := ModuleLevels()
+	delete(next, module)
+	moduleLevels.Store(&next)
+}
+
+func ModuleLevels() map[string]zerolog.Level {
+	if m := moduleLevels.Load(); m != nil {
+		return maps.Clone(*m)
+	}
+	return map[string]zerolog.Level{}
+}
+
+/* Debug (trace if already at debug) on the first call, the previous level on the next */
+func ToggleVerbose() zerolog.Level {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	if toggledLevel != nil {
+		globalLevel.Store(int32(*toggledLevel))
+		toggledLevel = nil
+		return Level()
+	}
+
+	prev := Level()
+	next := zerolog.DebugLevel
+	if prev <= zerolog.DebugLevel {
+		next = zerolog.TraceLevel
+	}
+	toggledLevel = &prev
+	globalLevel.Store(int32(next))
+	return next
+}
+
+func moduleEnabled(module string, level zerolog.Level) bool {
+	if module != "" {
+		if m := moduleLevels.Load(); m != nil {
+			if l, ok := (*m)[module]; ok {
+				return level >= l
+			}
+		}
+	}
+	return level >= Level()
+}
@@ -0,0 +1,159 @@
+package logger
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+const defaultBufferSize = 1000
+
+/* A log line as kept in memory, Fields holds everything but the standard keys */
+type Entry struct {
+	Seq     uint64         `json:"seq"`
+	Time    string         `json:"time"`
+	Level   string         `json:"level"`
+	Module  string         `json:"module,omitempty"`
+	Caller  string         `json:"caller,omitempty"`
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+/* Empty Module matches every entry, module names also match their file names */
+type Filter struct {
+	Module string
+	Level  zerolog.Level
+}
+
+func (f Filter) Match(e Entry) bool {
+	if f.Module != "" && e.Module != f.Module && strings.TrimSuffix(e.Module, filepath.Ext(e.Module)) != f.Module {
+		return false
+	}
+	l, err := zerolog.ParseLevel(e.Level)
+	return err != nil || l >= f.Level
+}
+
+/* The most recent entries, fed by the logger as one more writer */
+type ringBuffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+	seq     uint64
+	subs    map[chan Entry]struct{}
+}
+
+var ring = &ringBuffer{entries: make([]Entry, defaultBufferSize), subs: map[chan Entry]struct{}{}}
+
+/* Resizing keeps the newest entries that still fit, 0 disables the buffer */
+func SetBufferSize(n int) {
+	r := ring
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.snapshot()
+	if len(old) > n {
+		old = old[len(old)-n:]
+	}
+	r.entries = make([]Entry, n)
+	copy(r.entries, old)
+	r.next, r.full = len(old), len(old) == n
+	if r.next == n {
+		r.next = 0
+	}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	return r.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (r *ringBuffer) WriteLevel(_ zerolog.Level, p []byte) (int, error) {
+	/* Nothing to keep nor to send, skip decoding the line */
+	r.mu.Lock()
+	idle := len(r.entries) == 0 && len(r.subs) == 0
+	r.mu.Unlock()
+	if idle {
+		return len(p), nil
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(p, &raw); err != nil {
+		return len(p), nil
+	}
+
+	e := Entry{}
+	e.Time, _ = raw[zerolog.TimestampFieldName].(string)
+	e.Level, _ = raw[zerolog.LevelFieldName].(string)
+	e.Caller, _ = raw[zerolog.CallerFieldName].(string)
+	e.Message, _ = raw[zerolog.MessageFieldName].(string)
+	e.Module, _ = raw[MODULE_KEY].(string)
+	for _, k := range []string{zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.CallerFieldName, zerolog.MessageFieldName, MODULE_KEY} {
+		delete(raw, k)
+	}
+	if len(raw) > 0 {
+		e.Fields = raw
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	e.Seq = r.seq
+	if n := len(r.entries); n > 0 {
+		r.entries[r.next] = e
+		r.next = (r.next + 1) % n
+		r.full = r.full || r.next == 0
+	}
+	/* Slow subscribers miss entries rather than holding up logging */
+	for ch := range r.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+/* Oldest first, r.mu held */
+func (r *ringBuffer) snapshot() []Entry {
+	if !r.full {
+		return append([]Entry(nil), r.entries[:r.next]...)
+	}
+	return append(append([]Entry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+/* The newest limit (all if <= 0) buffered entries matching f, oldest first */
+func Recent(f Filter, limit int) []Entry {
+	ring.mu.Lock()
+	all := ring.snapshot()
+	ring.mu.Unlock()
+
+	out := []Entry{}
+	for i := len(all) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		if f.Match(all[i]) {
+			out = append(out, all[i])
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+/* Every entry logged from now on, until cancel is called */
+func Subscribe(buffer int) (<-chan Entry, func()) {
+	ch := make(chan Entry, buffer)
+	ring.mu.Lock()
+	ring.subs[ch] = struct{}{}
+	ring.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			ring.mu.Lock()
+			delete(ring.subs, ch)
+			ring.mu.Unlock()
+		})
+	}
+}
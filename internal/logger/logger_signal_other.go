@@ -0,0 +1,8 @@
+//go:build !unix
+
+package logger
+
+import "context"
+
+/* No SIGUSR1 here, levels can still be changed through the admin API */
+func HandleSignals(ctx context.Context) {}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package logger
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/* SIGUSR1 toggles the global level between verbose and the previous one */
+func HandleSignals(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				level := ToggleVerbose()
+				Info("Log level toggled by SIGUSR1", "log_level", level.String())
+			}
+		}
+	}()
+}
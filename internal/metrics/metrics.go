@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+const NAMESPACE = "omnirouter"
+
+/* Route labels of requests that never reached a route */
+const (
+	ROUTE_INVALID   = "invalid"   /* rejected by the path policy */
+	ROUTE_RULE      = "rule"      /* answered by a redirect/respond rule */
+	ROUTE_UNMATCHED = "unmatched" /* no route registered for the path */
+)
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route pattern, method, status and owning module.",
+	}, []string{"route", "method", "status", "module"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route pattern, method, status and owning module.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status", "module"})
+
+	moduleLoads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Subsystem: "module",
+		Name:      "loads_total",
+		Help:      "Successful module loads.",
+	}, []string{"module", "type"})
+
+	moduleReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Subsystem: "module",
+		Name:      "reloads_total",
+		Help:      "Module reloads, on file changes, admin requests and process restarts.",
+	}, []string{"module", "type"})
+
+	moduleFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Subsystem: "module",
+		Name:      "failures_total",
+		Help:      "Failed module loads (init returned false or the module could not be opened).",
+	}, []string{"module", "type"})
+
+	moduleUnloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Subsystem: "module",
+		Name:      "unloads_total",
+		Help:      "Module unloads.",
+	}, []string{"module", "type"})
+)
+
+var handler = fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, requestDuration,
+		moduleLoads, moduleReloads, moduleFailures, moduleUnloads,
+	)
+}
+
+/* Serves every registered metric in the Prometheus exposition format */
+func Serve(ctx *fasthttp.RequestCtx) {
+	handler(ctx)
+}
+
+/* Anything else a client sends as method, so it cannot add series at will */
+const METHOD_OTHER = "OTHER"
+
+func ObserveRequest(route, method string, status int, module string, d time.Duration) {
+	switch method {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodPost,
+		fasthttp.MethodPut, fasthttp.MethodDelete, fasthttp.MethodPatch:
+	default:
+		method = METHOD_OTHER
+	}
+	code := strconv.Itoa(status)
+	requests.WithLabelValues(route, method, code, module).Inc()
+	requestDuration.WithLabelValues(route, method, code, module).Observe(d.Seconds())
+}
+
+func ModuleLoaded(module, typ string) {
+	moduleLoads.WithLabelValues(module, typ).Inc()
+}
+
+func ModuleReloaded(module, typ string) {
+	moduleReloads.WithLabelValues(module, typ).Inc()
+}
+
+func ModuleFailed(module, typ string) {
+	moduleFailures.WithLabelValues(module, typ).Inc()
+}
+
+func ModuleUnloaded(module, typ string) {
+	moduleUnloads.WithLabelValues(module, typ).Inc()
+}
+
+/*
+ * Exports the connection stats of a fasthttp server under server="<name>".
+ * The values are read at scrape time.
+ */
+func RegisterServer(name string, s *fasthttp.Server) {
+	labels := prometheus.Labels{"server": name}
+	Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   NAMESPACE,
+			Subsystem:   "http",
+			Name:        "open_connections",
+			Help:        "Currently open client connections.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.GetOpenConnectionsCount()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   NAMESPACE,
+			Subsystem:   "http",
+			Name:        "concurrency",
+			Help:        "Connections currently being served.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.GetCurrentConcurrency()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   NAMESPACE,
+			Subsystem:   "http",
+			Name:        "rejected_connections_total",
+			Help:        "Connections rejected because of the concurrency or per-IP limits.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.GetRejectedConnectionsCount()) }),
+	)
+}
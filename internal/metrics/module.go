@@ -0,0 +1,266 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/* Same values as or_metric_kind_t in cffi.h */
+type Kind uint32
+
+const (
+	KIND_COUNTER   Kind = 1
+	KIND_GAUGE     Kind = 2
+	KIND_HISTOGRAM Kind = 3
+)
+
+var (
+	errUnknownMetric = errors.New("unknown metric")
+	errWrongKind     = errors.New("operation does not apply to this metric kind")
+	errNegative      = errors.New("counters can only go up")
+)
+
+/*
+ * The metrics a module created. They are exported as
+ * omnirouter_mod_<module>_<name> so modules cannot collide with each other or
+ * with the router's own metrics, and all go away with Unregister.
+ */
+type ModuleSet struct {
+	mu      sync.Mutex
+	prefix  string
+	metrics []moduleMetric
+	byName  map[string]uint64
+	closed  bool
+}
+
+type moduleMetric struct {
+	kind      Kind
+	labels    []string
+	collector prometheus.Collector
+	counter   *prometheus.CounterVec
+	gauge     *prometheus.GaugeVec
+	histogram *prometheus.HistogramVec
+}
+
+func NewModuleSet(module string) *ModuleSet {
+	return &ModuleSet{
+		prefix: NAMESPACE + "_mod_" + sanitize(module) + "_",
+		byName: make(map[string]uint64),
+	}
+}
+
+/* Module names may contain anything a file name can, metric names may not */
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+/*
+ * Creates and registers a metric, returning its handle (never 0). Creating
+ * the same name again with the same kind and labels returns the existing
+ * handle, so a restarted module worker keeps its series.
+ */
+func (s *ModuleSet) New(kind Kind, name, help string, labels []string, buckets []float64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, errors.New("module metrics are unregistered")
+	}
+
+	if id, ok := s.byName[name]; ok {
+		m := s.metrics[id-1]
+		if m.kind != kind || !equalLabels(m.labels, labels) {
+			return 0, fmt.Errorf("metric %q already exists with a different kind or labels", name)
+		}
+		return id, nil
+	}
+
+	/* Prometheus only validates histograms when the first series is created, by panicking */
+	if err := checkNames(name, labels); err != nil {
+		return 0, err
+	}
+	if kind == KIND_HISTOGRAM {
+		var err error
+		if buckets, err = checkBuckets(buckets, labels); err != nil {
+			return 0, err
+		}
+	}
+
+	if help == "" {
+		help = name
+	}
+	m := moduleMetric{kind: kind, labels: append([]string(nil), labels...)}
+	switch kind {
+	case KIND_COUNTER:
+		m.counter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: s.prefix + name, Help: help}, labels)
+		m.collector = m.counter
+	case KIND_GAUGE:
+		m.gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: s.prefix + name, Help: help}, labels)
+		m.collector = m.gauge
+	case KIND_HISTOGRAM:
+		m.histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: s.prefix + name, Help: help, Buckets: buckets}, labels)
+		m.collector = m.histogram
+	default:
+		return 0, fmt.Errorf("unknown metric kind %d", kind)
+	}
+
+	if err := Registry.Register(m.collector); err != nil {
+		return 0, err
+	}
+	s.metrics = append(s.metrics, m)
+	id := uint64(len(s.metrics))
+	s.byName[name] = id
+	return id, nil
+}
+
+func checkNames(name string, labels []string) error {
+	if !validName(name, true) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	seen := make(map[string]bool, len(labels))
+	for _, l := range labels {
+		if !validName(l, false) || strings.HasPrefix(l, "__") {
+			return fmt.Errorf("invalid label name %q", l)
+		}
+		if seen[l] {
+			return fmt.Errorf("duplicate label name %q", l)
+		}
+		seen[l] = true
+	}
+	return nil
+}
+
+/* [a-zA-Z_][a-zA-Z0-9_]*, metric names may also contain colons */
+func validName(s string, colon bool) bool {
+	for i, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || colon && r == ':' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+/* The default buckets if none, the +Inf bucket is implicit */
+func checkBuckets(buckets []float64, labels []string) ([]float64, error) {
+	for _, l := range labels {
+		if l == "le" {
+			return nil, errors.New(`histograms cannot have a label named "le"`)
+		}
+	}
+	if n := len(buckets); n > 0 && math.IsInf(buckets[n-1], 1) {
+		buckets = buckets[:n-1]
+	}
+	if len(buckets) == 0 {
+		return prometheus.DefBuckets, nil
+	}
+	for i, b := range buckets {
+		if math.IsNaN(b) || i > 0 && !(b > buckets[i-1]) {
+			return nil, errors.New("histogram buckets must be strictly increasing")
+		}
+	}
+	return append([]float64(nil), buckets...), nil
+}
+
+func equalLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *ModuleSet) get(id uint64) (moduleMetric, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || id == 0 || id > uint64(len(s.metrics)) {
+		return moduleMetric{}, errUnknownMetric
+	}
+	return s.metrics[id-1], nil
+}
+
+/* Counters and gauges */
+func (s *ModuleSet) Add(id uint64, v float64, labelValues []string) error {
+	m, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	switch m.kind {
+	case KIND_COUNTER:
+		if v < 0 {
+			return errNegative
+		}
+		c, err := m.counter.GetMetricWithLabelValues(labelValues...)
+		if err != nil {
+			return err
+		}
+		c.Add(v)
+	case KIND_GAUGE:
+		g, err := m.gauge.GetMetricWithLabelValues(labelValues...)
+		if err != nil {
+			return err
+		}
+		g.Add(v)
+	default:
+		return errWrongKind
+	}
+	return nil
+}
+
+/* Gauges only */
+func (s *ModuleSet) Set(id uint64, v float64, labelValues []string) error {
+	m, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	if m.kind != KIND_GAUGE {
+		return errWrongKind
+	}
+	g, err := m.gauge.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		return err
+	}
+	g.Set(v)
+	return nil
+}
+
+/* Histograms only */
+func (s *ModuleSet) Observe(id uint64, v float64, labelValues []string) error {
+	m, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	if m.kind != KIND_HISTOGRAM {
+		return errWrongKind
+	}
+	h, err := m.histogram.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		return err
+	}
+	h.Observe(v)
+	return nil
+}
+
+/* Drops every metric of the module from the endpoint, the set is unusable afterwards */
+func (s *ModuleSet) Unregister() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.metrics {
+		Registry.Unregister(m.collector)
+	}
+	s.metrics = nil
+	s.byName = nil
+	s.closed = true
+}
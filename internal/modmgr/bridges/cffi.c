@@ -5,14 +5,37 @@
 #include <stdint.h>
 #include <stdbool.h>
 
+/*
+ * The module (and request) calling on this thread, so the api's log
+ * functions can honor the module's log level and tag the request ID. Logs
+ * from threads of the module's own have neither.
+ */
+static _Thread_local muid_t current_muid;
+static _Thread_local or_http_req_t* current_req;
+
+#define REQUEST_ID (current_req ? (char*) current_req->request_id : NULL)
+
+/* Same values as LOG_* in host.go */
+static void api_loginfo(char* msg, char* module_) { or_api_log(current_muid, 0, msg, module_, REQUEST_ID); }
+static void api_logwarn(char* msg, char* module_) { or_api_log(current_muid, 1, msg, module_, REQUEST_ID); }
+static void api_logerror(char* msg, char* module_) { or_api_log(current_muid, 2, msg, module_, REQUEST_ID); }
+static void api_logfatal(char* msg, char* module_) { or_api_log(current_muid, 3, msg, module_, REQUEST_ID); }
+
 static const or_api_t api = {
     .version  = MODLOADER_VERSION,
-    .loginfo  = or_loginfo,
-    .logwarn  = or_logwarn,
-    .logerror = or_logerror,
-    .logfatal = or_logfatal,
+    .loginfo  = api_loginfo,
+    .logwarn  = api_logwarn,
+    .logerror = api_logerror,
+    .logfatal = api_logfatal,
     .register_http = or_register_http,
-    .unregister_http = or_unregister_http
+    .unregister_http = or_unregister_http,
+    .register_http_ex = or_register_http_ex,
+    .unregister_http_ex = or_unregister_http_ex,
+    .report_health = or_report_health,
+    .metric_new = or_metric_new,
+    .metric_add = or_metric_add,
+    .metric_set = or_metric_set,
+    .metric_observe = or_metric_observe
 };
 
 static loadmod_err_t error_reg;
@@ -39,8 +62,14 @@ inline static void set_error(loadmod_err_t error) {
     error_reg = error;
 }
 
-void call_or_http_handler(or_http_handler_t fn, or_ctx_t* ctx, or_http_req_t* req, void* extra) {
+void call_or_http_handler(muid_t muid, or_http_handler_t fn, or_ctx_t* ctx, or_http_req_t* req, void* extra) {
+    muid_t prev_muid = current_muid;
+    or_http_req_t* prev_req = current_req;
+    current_muid = muid;
+    current_req = req;
     fn(ctx, req, extra);
+    current_muid = prev_muid;
+    current_req = prev_req;
 }
 
 #define INIT_FUNC_FAIL "Warning: init function for \"%s\" returned false (failed state)"
@@ -49,7 +78,9 @@ inline static loadmod_err_t cffi_common_init_call(char* path, init_func_t init_f
     loadmod_err_t ret = LOADMOD_SUCCESS;
 
     /* Call init function */
+    current_muid = muid;
     bool success = init_func(muid, &api);
+    current_muid = 0;
     if (!success) {
         uint32_t len = strlen(path) + sizeof(INIT_FUNC_FAIL);
         char* buf = alloca(len);
@@ -99,17 +130,22 @@ inline static mod_handle_t cffi_load_so(char* path, muid_t muid) {
     return handle;
 }
 
-inline static void cffi_unload_so(mod_handle_t handle, muid_t muid) {
-    uninit_func_t uninit_func = (uninit_func_t) dlsym(handle, "uninit");
-    char* error = dlerror();
-    if (error != NULL) {
-        uint32_t len = strlen(error) + sizeof(DLSYM_UNINIT_ERROR_MSG);
-        char* buf = alloca(len);
-        snprintf(buf, len, DLSYM_UNINIT_ERROR_MSG, error);
-        log_error(buf);
-        set_error(LOADMOD_NO_VALID_UNINIT_FUNC);
-    } else {
-        uninit_func(muid, &api);
+inline static void cffi_unload_so(mod_handle_t handle, muid_t muid, bool call_uninit) {
+    /* uninit only pairs with a successful init */
+    if (call_uninit) {
+        uninit_func_t uninit_func = (uninit_func_t) dlsym(handle, "uninit");
+        char* error = dlerror();
+        if (error != NULL) {
+            uint32_t len = strlen(error) + sizeof(DLSYM_UNINIT_ERROR_MSG);
+            char* buf = alloca(len);
+            snprintf(buf, len, DLSYM_UNINIT_ERROR_MSG, error);
+            log_error(buf);
+            set_error(LOADMOD_NO_VALID_UNINIT_FUNC);
+        } else {
+            current_muid = muid;
+            uninit_func(muid, &api);
+            current_muid = 0;
+        }
     }
 
     if (dlclose(handle) != 0) {
@@ -158,21 +194,26 @@ inline static mod_handle_t cffi_load_dll(char* path, muid_t muid) {
     return handle;
 }
 
-inline static void cffi_unload_dll(mod_handle_t handle, muid_t muid) {
-    uninit_func_t uninit_func = (uninit_func_t) GetProcAddress(handle, "uninit");
-    if (uninit_func == NULL) {
-        DWORD error_nr = GetLastError();
-        char *msg = NULL;
-        FormatMessageA(FORMAT_MESSAGE_ALLOCATE_BUFFER | FORMAT_MESSAGE_FROM_SYSTEM
-             | FORMAT_MESSAGE_IGNORE_INSERTS, NULL, error_nr, 0, (LPSTR)&msg, 0, NULL);
-        uint32_t len = strlen(msg) + sizeof(GETPROCADDRESS_UNINIT_ERROR_MSG) + MAX_UINT64_HEX_LEN;
-        char* buf = _alloca(len);
-        _snprintf(buf, len, GETPROCADDRESS_UNINIT_ERROR_MSG, (unsigned)error_nr, msg ? msg : "unknown");
-        log_error(buf);
-        if (msg) LocalFree(msg);
-        set_error(LOADMOD_NO_VALID_UNINIT_FUNC);
-    } else {
-        uninit_func(muid, &api);
+inline static void cffi_unload_dll(mod_handle_t handle, muid_t muid, bool call_uninit) {
+    /* uninit only pairs with a successful init */
+    if (call_uninit) {
+        uninit_func_t uninit_func = (uninit_func_t) GetProcAddress(handle, "uninit");
+        if (uninit_func == NULL) {
+            DWORD error_nr = GetLastError();
+            char *msg = NULL;
+            FormatMessageA(FORMAT_MESSAGE_ALLOCATE_BUFFER | FORMAT_MESSAGE_FROM_SYSTEM
+                 | FORMAT_MESSAGE_IGNORE_INSERTS, NULL, error_nr, 0, (LPSTR)&msg, 0, NULL);
+            uint32_t len = strlen(msg) + sizeof(GETPROCADDRESS_UNINIT_ERROR_MSG) + MAX_UINT64_HEX_LEN;
+            char* buf = _alloca(len);
+            _snprintf(buf, len, GETPROCADDRESS_UNINIT_ERROR_MSG, (unsigned)error_nr, msg ? msg : "unknown");
+            log_error(buf);
+            if (msg) LocalFree(msg);
+            set_error(LOADMOD_NO_VALID_UNINIT_FUNC);
+        } else {
+            current_muid = muid;
+            uninit_func(muid, &api);
+            current_muid = 0;
+        }
     }
 
     if (FreeLibrary(handle) == false) {
@@ -190,6 +231,31 @@ inline static void cffi_unload_dll(mod_handle_t handle, muid_t muid) {
 
 #endif
 
+#define LOOKUP_HANDLER_ERROR_MSG "Handler symbol \"%s\" not found in module"
+
+or_http_handler_t cffi_lookup_handler(mod_handle_t handle, char* name) {
+    void* sym = NULL;
+    #ifdef __linux__
+        /* Clear errors */
+        dlerror();
+        sym = dlsym(handle, name);
+        if (dlerror() != NULL) {
+            sym = NULL;
+        }
+    #elif _WIN32
+        sym = (void*) GetProcAddress(handle, name);
+    #endif
+
+    if (sym == NULL) {
+        uint32_t len = strlen(name) + sizeof(LOOKUP_HANDLER_ERROR_MSG);
+        char* buf = alloca(len);
+        snprintf(buf, len, LOOKUP_HANDLER_ERROR_MSG, name);
+        log_error(buf);
+    }
+
+    return (or_http_handler_t) sym;
+}
+
 mod_handle_t cffi_load_module(char* path, muid_t muid) {
     #ifdef __linux__
         return cffi_load_so(path, muid);
@@ -203,11 +269,11 @@ mod_handle_t cffi_load_module(char* path, muid_t muid) {
     return NULL;
 }
 
-void cffi_unload_module(mod_handle_t handle, muid_t muid) {
+void cffi_unload_module(mod_handle_t handle, muid_t muid, bool call_uninit) {
     #ifdef __linux__
-        cffi_unload_so(handle, muid);
+        cffi_unload_so(handle, muid, call_uninit);
     #elif _WIN32
-        cffi_unload_dll(handle, muid);
+        cffi_unload_dll(handle, muid, call_uninit);
     #else
         log_error("Unsupported OS detected!");
     #endif
@@ -4,7 +4,7 @@
 #include <stdint.h>
 #include <stdbool.h>
 
-#define MODLOADER_VERSION 4
+#define MODLOADER_VERSION 9
 #define MAX_VERSION_LENGTH 20
 
 /* Exported functions from logger_cffi.go */
@@ -48,14 +48,43 @@ typedef enum {
     OR_METHOD_ANY = ~((uint8_t) 0)
 } or_method_t;
 
+typedef enum {
+    OR_PRED_UNKNOWN = 0,
+    OR_PRED_HEADER = 1,
+    OR_PRED_QUERY = 2,
+    OR_PRED_ACCEPT = 3,
+    OR_PRED_CONTENT_TYPE = 4
+} or_pred_kind_t;
+
+/* For HEADER/QUERY an empty (or NULL) value only checks presence of name */
+typedef struct {
+    or_pred_kind_t kind;
+    char* name;
+    char* value;
+} or_predicate_t;
+
 typedef uint64_t muid_t;
 
+/* Reported through report_health, UNHEALTHY fails readiness of a required module */
+typedef enum {
+    OR_HEALTH_OK = 0,
+    OR_HEALTH_DEGRADED = 1,
+    OR_HEALTH_UNHEALTHY = 2
+} or_health_t;
+
 typedef struct {
 
 } or_ctx_t;
 
+/*
+ * Only valid for the duration of the handler call. Log calls made through
+ * the api from the handler's thread carry the request ID.
+ */
 typedef struct {
-
+    const char* path;        /* full canonical request path */
+    const char* mount_path;  /* same path relative to the module's mount */
+    const char* request_id;  /* X-Request-ID of the request, echoed in the response */
+    const char* traceparent; /* W3C trace context, our span as parent */
 } or_http_req_t;
 
 typedef void (*or_http_handler_t)(
@@ -64,6 +93,22 @@ typedef void (*or_http_handler_t)(
     void* extra
 );
 
+/*
+ * Module metrics, exported as omnirouter_mod_<module>_<name> (needs the
+ * `metrics` capability). Counters take add (value >= 0), gauges add and set,
+ * histograms observe; label values are given in the order of the labels the
+ * metric was created with. NULL buckets use the default histogram buckets.
+ * All of a module's metrics are removed when it unloads.
+ */
+typedef enum {
+    OR_METRIC_COUNTER = 1,
+    OR_METRIC_GAUGE = 2,
+    OR_METRIC_HISTOGRAM = 3
+} or_metric_kind_t;
+
+/* 0 is never a valid metric, metric_new returns it on failure */
+typedef uint64_t or_metric_t;
+
 typedef struct {
     uint64_t version;
     void (*loginfo)(char* msg, char* module_);
@@ -72,6 +117,13 @@ typedef struct {
     void (*logfatal)(char* msg, char* module_);
     uint64_t (*register_http)(muid_t muid, or_method_t method_mask, char* path, or_http_handler_t handler, void* extra);
     uint64_t (*unregister_http)(muid_t muid, or_method_t method_mask, char* path);
+    uint64_t (*register_http_ex)(muid_t muid, or_method_t method_mask, char* path, or_predicate_t* preds, uint32_t pred_count, or_http_handler_t handler, void* extra);
+    uint64_t (*unregister_http_ex)(muid_t muid, or_method_t method_mask, char* path, or_predicate_t* preds, uint32_t pred_count);
+    uint64_t (*report_health)(muid_t muid, or_health_t status, char* detail);
+    or_metric_t (*metric_new)(muid_t muid, or_metric_kind_t kind, char* name, char* help, char** labels, uint32_t label_count, double* buckets, uint32_t bucket_count);
+    uint64_t (*metric_add)(muid_t muid, or_metric_t metric, double value, char** label_values, uint32_t label_count);
+    uint64_t (*metric_set)(muid_t muid, or_metric_t metric, double value, char** label_values, uint32_t label_count);
+    uint64_t (*metric_observe)(muid_t muid, or_metric_t metric, double value, char** label_values, uint32_t label_count);
 } or_api_t;
 
 typedef struct {
@@ -84,6 +136,14 @@ typedef bool (*uninit_func_t)(muid_t muid, const or_api_t* api);
 /* Exported functions from modmgr.go */
 extern uint64_t or_register_http(muid_t muid, or_method_t method_mask, char* path, or_http_handler_t handler, void* extra);
 extern uint64_t or_unregister_http(muid_t muid, or_method_t method_mask, char* path);
+extern uint64_t or_register_http_ex(muid_t muid, or_method_t method_mask, char* path, or_predicate_t* preds, uint32_t pred_count, or_http_handler_t handler, void* extra);
+extern uint64_t or_unregister_http_ex(muid_t muid, or_method_t method_mask, char* path, or_predicate_t* preds, uint32_t pred_count);
+extern uint64_t or_report_health(muid_t muid, or_health_t status, char* detail);
+extern void or_api_log(muid_t muid, uint32_t level, char* msg, char* module_, char* request_id);
+extern or_metric_t or_metric_new(muid_t muid, or_metric_kind_t kind, char* name, char* help, char** labels, uint32_t label_count, double* buckets, uint32_t bucket_count);
+extern uint64_t or_metric_add(muid_t muid, or_metric_t metric, double value, char** label_values, uint32_t label_count);
+extern uint64_t or_metric_set(muid_t muid, or_metric_t metric, double value, char** label_values, uint32_t label_count);
+extern uint64_t or_metric_observe(muid_t muid, or_metric_t metric, double value, char** label_values, uint32_t label_count);
 
 #ifdef __linux__
     #include <dlfcn.h>
@@ -104,8 +164,10 @@ extern uint64_t or_unregister_http(muid_t muid, or_method_t method_mask, char* p
 /* cffi.c exports */
 bool cffi_health(void);
 mod_handle_t cffi_load_module(char* path, muid_t muid);
-void cffi_unload_module(mod_handle_t handle, muid_t muid);
-void call_or_http_handler(or_http_handler_t fn, or_ctx_t* ctx, or_http_req_t* req, void* extra);
+/* handle must not be NULL, call_uninit only after a successful init */
+void cffi_unload_module(mod_handle_t handle, muid_t muid, bool call_uninit);
+or_http_handler_t cffi_lookup_handler(mod_handle_t handle, char* name);
+void call_or_http_handler(muid_t muid, or_http_handler_t fn, or_ctx_t* ctx, or_http_req_t* req, void* extra);
 loadmod_err_t get_error(void);
 
 #endif // CFFI_H
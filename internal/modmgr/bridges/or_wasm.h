@@ -0,0 +1,124 @@
+#ifndef OR_WASM_H
+#define OR_WASM_H
+
+/*
+ * Guest side of the WASM module host API, the counterpart of or_api_t for
+ * `.wasm` modules (wasi-sdk / clang --target=wasm32-wasi, built as a reactor).
+ *
+ * A module exports `init` and `uninit` (no arguments, returning non-zero on
+ * success) and its handlers, which take the `extra` value given at
+ * registration. Handlers are referenced by export name, both here and in the
+ * [[routes]] `handler` config key.
+ *
+ * The req_* accessors are only valid inside a handler. They copy into `buf`
+ * only if `size` is large enough and always return the full length, or
+ * OR_WASM_NONE outside a handler (and for a missing header).
+ */
+
+#include <stdint.h>
+
+#define OR_WASM_NONE ((uint32_t) ~0u)
+
+#define OR_WASM_IMPORT(name) __attribute__((import_module("omnirouter"), import_name(#name)))
+#define OR_WASM_EXPORT(name) __attribute__((export_name(#name)))
+
+typedef enum {
+    OR_LOG_INFO = 0,
+    OR_LOG_WARN = 1,
+    OR_LOG_ERROR = 2,
+    OR_LOG_FATAL = 3
+} or_log_level_t;
+
+/* Same values as or_method_t / or_pred_kind_t in cffi.h */
+#define OR_WASM_METHOD_GET     (1 << 1)
+#define OR_WASM_METHOD_HEAD    (1 << 2)
+#define OR_WASM_METHOD_POST    (1 << 3)
+#define OR_WASM_METHOD_PUT     (1 << 4)
+#define OR_WASM_METHOD_DELETE  (1 << 5)
+#define OR_WASM_METHOD_PATCH   (1 << 6)
+#define OR_WASM_METHOD_OPTIONS (1 << 7)
+#define OR_WASM_METHOD_ANY     0xff
+
+#define OR_WASM_PRED_HEADER       1
+#define OR_WASM_PRED_QUERY        2
+#define OR_WASM_PRED_ACCEPT       3
+#define OR_WASM_PRED_CONTENT_TYPE 4
+
+typedef struct {
+    uint32_t kind;
+    const char* name;
+    uint32_t name_len;
+    const char* value;
+    uint32_t value_len;
+} or_wasm_predicate_t;
+
+/* Calls made from a handler are logged with the request ID */
+OR_WASM_IMPORT(log)
+void or_log(or_log_level_t level, const char* msg, uint32_t msg_len);
+
+/* Return values are the same error codes as or_api_t's */
+OR_WASM_IMPORT(register_http)
+uint64_t or_register_http(uint32_t method_mask, const char* path, uint32_t path_len,
+                          const char* handler, uint32_t handler_len, uint32_t extra);
+OR_WASM_IMPORT(unregister_http)
+uint64_t or_unregister_http(uint32_t method_mask, const char* path, uint32_t path_len);
+OR_WASM_IMPORT(register_http_ex)
+uint64_t or_register_http_ex(uint32_t method_mask, const char* path, uint32_t path_len,
+                             const or_wasm_predicate_t* preds, uint32_t pred_count,
+                             const char* handler, uint32_t handler_len, uint32_t extra);
+OR_WASM_IMPORT(unregister_http_ex)
+uint64_t or_unregister_http_ex(uint32_t method_mask, const char* path, uint32_t path_len,
+                               const or_wasm_predicate_t* preds, uint32_t pred_count);
+
+#define OR_WASM_HEALTH_OK        0
+#define OR_WASM_HEALTH_DEGRADED  1
+#define OR_WASM_HEALTH_UNHEALTHY 2
+
+OR_WASM_IMPORT(report_health)
+uint64_t or_report_health(uint32_t status, const char* detail, uint32_t detail_len);
+
+/* Same as or_metric_kind_t, see cffi.h for the metric semantics */
+#define OR_WASM_METRIC_COUNTER   1
+#define OR_WASM_METRIC_GAUGE     2
+#define OR_WASM_METRIC_HISTOGRAM 3
+
+/*
+ * Label names and values are NUL separated lists ("method\0status"), of
+ * `labels_len` bytes in total; buckets is an array of `bucket_count` doubles.
+ * metric_new returns 0 on failure.
+ */
+OR_WASM_IMPORT(metric_new)
+uint64_t or_metric_new(uint32_t kind, const char* name, uint32_t name_len, const char* help, uint32_t help_len,
+                       const char* labels, uint32_t labels_len, const double* buckets, uint32_t bucket_count);
+OR_WASM_IMPORT(metric_add)
+uint64_t or_metric_add(uint64_t metric, double value, const char* label_values, uint32_t label_values_len);
+OR_WASM_IMPORT(metric_set)
+uint64_t or_metric_set(uint64_t metric, double value, const char* label_values, uint32_t label_values_len);
+OR_WASM_IMPORT(metric_observe)
+uint64_t or_metric_observe(uint64_t metric, double value, const char* label_values, uint32_t label_values_len);
+
+OR_WASM_IMPORT(req_path)
+uint32_t or_req_path(char* buf, uint32_t size);
+OR_WASM_IMPORT(req_mount_path)
+uint32_t or_req_mount_path(char* buf, uint32_t size);
+OR_WASM_IMPORT(req_method)
+uint32_t or_req_method(char* buf, uint32_t size);
+OR_WASM_IMPORT(req_query)
+uint32_t or_req_query(char* buf, uint32_t size);
+OR_WASM_IMPORT(req_header)
+uint32_t or_req_header(const char* name, uint32_t name_len, char* buf, uint32_t size);
+OR_WASM_IMPORT(req_body)
+uint32_t or_req_body(char* buf, uint32_t size);
+OR_WASM_IMPORT(req_request_id)
+uint32_t or_req_request_id(char* buf, uint32_t size);
+OR_WASM_IMPORT(req_traceparent)
+uint32_t or_req_traceparent(char* buf, uint32_t size);
+
+OR_WASM_IMPORT(resp_status)
+void or_resp_status(uint32_t status);
+OR_WASM_IMPORT(resp_header)
+void or_resp_header(const char* name, uint32_t name_len, const char* value, uint32_t value_len);
+OR_WASM_IMPORT(resp_write)
+void or_resp_write(const char* data, uint32_t len);
+
+#endif // OR_WASM_H
@@ -0,0 +1,78 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Time spent in C handlers, per module. Measured around the whole cgo call,
+ * so it includes the cgo transition and whatever the handler calls back into.
+ */
+type CgoHandlerStats struct {
+	Module   string  `json:"module"`
+	Calls    uint64  `json:"calls"`
+	InFlight int64   `json:"in_flight"`
+	Seconds  float64 `json:"seconds"`
+	Max      float64 `json:"max_seconds"`
+}
+
+/* Calls counts every cgo call of the process, not only handler calls */
+type CgoStats struct {
+	Calls    int64             `json:"calls"`
+	Handlers []CgoHandlerStats `json:"handlers"`
+}
+
+type cgoCounter struct {
+	calls    atomic.Uint64
+	inFlight atomic.Int64
+	nanos    atomic.Uint64
+	max      atomic.Uint64
+}
+
+var cgoCounters sync.Map /* module name -> *cgoCounter */
+
+func cgoCounterFor(module string) *cgoCounter {
+	if c, ok := cgoCounters.Load(module); ok {
+		return c.(*cgoCounter)
+	}
+	c, _ := cgoCounters.LoadOrStore(module, &cgoCounter{})
+	return c.(*cgoCounter)
+}
+
+/* Returns the func ending the call */
+func (c *cgoCounter) begin() func() {
+	c.inFlight.Add(1)
+	start := time.Now()
+	return func() {
+		d := uint64(time.Since(start))
+		c.inFlight.Add(-1)
+		c.calls.Add(1)
+		c.nanos.Add(d)
+		for m := c.max.Load(); d > m && !c.max.CompareAndSwap(m, d); m = c.max.Load() {
+		}
+	}
+}
+
+/* Counters survive reloads, so they cover every version of a module */
+func GetCgoStats() CgoStats {
+	out := CgoStats{Calls: runtime.NumCgoCall(), Handlers: []CgoHandlerStats{}}
+	cgoCounters.Range(func(k, v any) bool {
+		c := v.(*cgoCounter)
+		out.Handlers = append(out.Handlers, CgoHandlerStats{
+			Module:   k.(string),
+			Calls:    c.calls.Load(),
+			InFlight: c.inFlight.Load(),
+			Seconds:  time.Duration(c.nanos.Load()).Seconds(),
+			Max:      time.Duration(c.max.Load()).Seconds(),
+		})
+		return true
+	})
+	sort.Slice(out.Handlers, func(i, j int) bool { return out.Handlers[i].Module < out.Handlers[j].Module })
+	return out
+}
@@ -0,0 +1,92 @@
+//go:build cgo
+
+package modmgr
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/bridges
+#include "bridges/cffi.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/router"
+	"unsafe"
+)
+
+type configRoute struct {
+	methodMask uint8
+	path       string
+}
+
+/* Unregistering on the module's behalf must not depend on its capabilities */
+var systemCaps = capabilities.Unrestricted()
+
+/*
+ * Registers the [[routes]] entries pointing to this module, just like the
+ * module would through or_register_http (capabilities and mount apply).
+ */
+func (mod *Module) registerConfigRoutes() {
+	mod.routes = mod.addConfigRoutes()
+}
+
+func (mod *Module) addConfigRoutes() []configRoute {
+	var added []configRoute
+	for _, r := range moduleRoutes(mod.filename) {
+		mask, err := router.ParseMethods(r.Methods)
+		if err != nil {
+			logger.Error("Invalid methods in route config", "path", r.Path, "module", r.Module, "err", err)
+			continue
+		}
+
+		h := mod.routeHandler(r.Handler)
+		if h == nil {
+			logger.Error("Could not resolve route handler", "path", r.Path, "module", r.Module, "handler", r.Handler)
+			continue
+		}
+
+		path := router.JoinMount(mod.mount, r.Pattern())
+		if router.GetHTTPRouter().Register(mod.capabilities, mask, path, h) != router.SUCCESS {
+			continue
+		}
+		added = append(added, configRoute{methodMask: mask, path: path})
+	}
+	return added
+}
+
+/* Must run before the library is closed, the handlers point into it */
+func (mod *Module) unregisterConfigRoutes() {
+	for _, r := range mod.routes {
+		router.GetHTTPRouter().Unregister(systemCaps, r.methodMask, r.path)
+	}
+}
+
+/* The handler named by a [[routes]] entry, nil if the module has no such symbol/export */
+func (mod *Module) routeHandler(name string) router.HTTPHandler {
+	switch mod.type_ {
+	case MODTYPE_WASM:
+		return mod.wasmHandler(name, 0)
+	case MODTYPE_SCRIPT:
+		return mod.scriptHandler(name)
+	case MODTYPE_PROCESS:
+		return mod.procHandler(name)
+	case MODTYPE_NATIVE:
+		return mod.nativeHandler(name)
+	}
+	fn := mod.lookupHandler(name)
+	if fn == nil {
+		return nil
+	}
+	return cHandler{muid: mod.muid, fn: fn, extra: nil, mount: mod.mount, owner: moduleName(mod.filename)}
+}
+
+func (mod *Module) lookupHandler(symbol string) C.or_http_handler_t {
+	if mod.handle == nil {
+		return nil
+	}
+	csym := C.CString(symbol)
+	defer C.free(unsafe.Pointer(csym))
+	return C.cffi_lookup_handler(mod.handle, csym)
+}
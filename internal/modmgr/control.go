@@ -0,0 +1,167 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"errors"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/metrics"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrUnknownModule   = errors.New("unknown module")
+	ErrModuleDisabled  = errors.New("module is disabled")
+	ErrModuleLoaded    = errors.New("module is already loaded")
+	ErrModuleNotLoaded = errors.New("module is not loaded")
+	ErrModuleFailed    = errors.New("module failed to load")
+)
+
+/* Disabled modules stay known but are not loaded, not even on file changes */
+var (
+	disabledMu sync.Mutex
+	disabled   = make(map[string]bool)
+)
+
+func isDisabled(filename string) bool {
+	disabledMu.Lock()
+	defer disabledMu.Unlock()
+	return disabled[moduleName(filename)]
+}
+
+func setDisabled(filename string, v bool) {
+	disabledMu.Lock()
+	defer disabledMu.Unlock()
+	if v {
+		disabled[moduleName(filename)] = true
+	} else {
+		delete(disabled, moduleName(filename))
+	}
+}
+
+/*
+ * Runs fn on the module called name (file name, the name without extension
+ * as used in [module.<name>], or its MUID in hex) with the module maps
+ * locked, so it cannot race with the file watcher.
+ */
+func withModule(name string, fn func(mod *Module) error) error {
+	mirrorMu.Lock()
+	defer mirrorMu.Unlock()
+	nativeMu.Lock()
+	defer nativeMu.Unlock()
+
+	known := make([]*Module, 0, len(src2mod)+len(nativeMods))
+	for _, mod := range src2mod {
+		known = append(known, mod)
+	}
+	known = append(known, nativeMods...)
+
+	var byName *Module
+	for _, mod := range known {
+		if mod.filename == name {
+			return fn(mod)
+		}
+		if byName == nil && moduleName(mod.filename) == name {
+			byName = mod
+		}
+	}
+	if byName != nil {
+		return fn(byName)
+	}
+
+	/* muidMap keeps stale entries, only a module that is still known counts */
+	if muid, err := strconv.ParseUint(name, 16, 64); err == nil {
+		if v, ok := muidMap.Load(MUID(muid)); ok {
+			if mod, ok := v.(*Module); ok && slices.Contains(known, mod) && mod.muid == MUID(muid) {
+				return fn(mod)
+			}
+		}
+	}
+	return ErrUnknownModule
+}
+
+func loadModule(mod *Module) error {
+	if isDisabled(mod.filename) {
+		return ErrModuleDisabled
+	}
+	switch mod.State() {
+	case MODSTATE_LOADED, MODSTATE_STARTING, MODSTATE_RESTARTING:
+		return ErrModuleLoaded
+	}
+
+	if mod.type_ == MODTYPE_NATIVE {
+		mod.Load()
+	} else if err := mod.Stage(); err != nil {
+		return err
+	}
+	if mod.State() == MODSTATE_FAILED {
+		return ErrModuleFailed
+	}
+	return nil
+}
+
+func unloadModule(mod *Module) error {
+	if mod.State() == MODSTATE_UNLOADED {
+		return ErrModuleNotLoaded
+	}
+	if mod.type_ == MODTYPE_NATIVE {
+		mod.Unload()
+		return nil
+	}
+	return mod.Unstage()
+}
+
+func LoadModule(name string) error {
+	return withModule(name, func(mod *Module) error {
+		logger.Info("Loading module on request", "module", mod.filename)
+		return loadModule(mod)
+	})
+}
+
+func UnloadModule(name string) error {
+	return withModule(name, func(mod *Module) error {
+		logger.Info("Unloading module on request", "module", mod.filename)
+		return unloadModule(mod)
+	})
+}
+
+/* Unload (if needed) and load again, file based modules are copied anew */
+func RestartModule(name string) error {
+	return withModule(name, func(mod *Module) error {
+		logger.Info("Reloading module on request", "module", mod.filename)
+		if isDisabled(mod.filename) {
+			return ErrModuleDisabled
+		}
+		if mod.State() != MODSTATE_UNLOADED {
+			if err := unloadModule(mod); err != nil {
+				return err
+			}
+		}
+		metrics.ModuleReloaded(moduleName(mod.filename), mod.type_.String())
+		return loadModule(mod)
+	})
+}
+
+func EnableModule(name string) error {
+	return withModule(name, func(mod *Module) error {
+		logger.Info("Enabling module", "module", mod.filename)
+		setDisabled(mod.filename, false)
+		if mod.State() != MODSTATE_UNLOADED {
+			return nil
+		}
+		return loadModule(mod)
+	})
+}
+
+func DisableModule(name string) error {
+	return withModule(name, func(mod *Module) error {
+		logger.Info("Disabling module", "module", mod.filename)
+		setDisabled(mod.filename, true)
+		if mod.State() == MODSTATE_UNLOADED {
+			return nil
+		}
+		return unloadModule(mod)
+	})
+}
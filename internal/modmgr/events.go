@@ -0,0 +1,158 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"omnirouter/internal/router"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ * Module lifecycle events, in the order a hot reload produces them:
+ * discovered (a new module file or native registration), staged (copied into
+ * the mirrordir, Version is set from here on), loaded or init_failed, and
+ * unloaded. route_changed follows every route (un)registration of a module.
+ */
+type EventType string
+
+const (
+	EVENT_DISCOVERED    EventType = "discovered"
+	EVENT_STAGED        EventType = "staged"
+	EVENT_LOADED        EventType = "loaded"
+	EVENT_INIT_FAILED   EventType = "init_failed"
+	EVENT_UNLOADED      EventType = "unloaded"
+	EVENT_ROUTE_CHANGED EventType = "route_changed"
+)
+
+/* Version is the SHA-256 of the staged module file, empty for native modules */
+type Event struct {
+	Seq     uint64              `json:"seq"`
+	Type    EventType           `json:"type"`
+	Time    time.Time           `json:"time"`
+	Module  string              `json:"module"`
+	ModType string              `json:"module_type,omitempty"`
+	MUID    string              `json:"muid,omitempty"`
+	Version string              `json:"version,omitempty"`
+	Route   *router.RouteChange `json:"route,omitempty"`
+}
+
+const eventHistory = 256
+
+var events = struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []Event
+	subs    map[chan Event]struct{}
+}{subs: map[chan Event]struct{}{}}
+
+func init() {
+	router.SetRouteListener(func(c router.RouteChange) {
+		if c.Owner == "" {
+			return
+		}
+		publish(Event{Type: EVENT_ROUTE_CHANGED, Module: c.Owner, Route: &c})
+	})
+}
+
+/*
+ * Every event published from now on, until cancel is called, preceded by
+ * the buffered ones after seq `since` (none for 0). Slow subscribers miss
+ * events rather than holding up module management, size buffer accordingly.
+ */
+func Subscribe(since uint64, buffer int) ([]Event, <-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	events.mu.Lock()
+	var backlog []Event
+	if since > 0 {
+		for _, e := range events.history {
+			if e.Seq > since {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+	events.subs[ch] = struct{}{}
+	events.mu.Unlock()
+
+	var once sync.Once
+	return backlog, ch, func() {
+		once.Do(func() {
+			events.mu.Lock()
+			delete(events.subs, ch)
+			events.mu.Unlock()
+		})
+	}
+}
+
+/* The buffered events, oldest first */
+func RecentEvents() []Event {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	return append([]Event(nil), events.history...)
+}
+
+func publish(e Event) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+
+	events.seq++
+	e.Seq = events.seq
+	e.Time = time.Now().UTC()
+	if len(events.history) == eventHistory {
+		events.history = append(events.history[:0], events.history[1:]...)
+	}
+	events.history = append(events.history, e)
+
+	for ch := range events.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (mod *Module) publish(t EventType) {
+	publish(Event{
+		Type:    t,
+		Module:  moduleName(mod.filename),
+		ModType: mod.type_.String(),
+		MUID:    muidString(mod.muid),
+		Version: mod.Version(),
+	})
+}
+
+/* Empty before the first load */
+func muidString(muid MUID) string {
+	if muid == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%016x", uint64(muid))
+}
+
+func (mod *Module) Version() string {
+	if v := mod.version.Load(); v != nil {
+		return *v
+	}
+	return ""
+}
+
+/* Hash of the staged copy, which is what gets loaded */
+func (mod *Module) setVersion() {
+	f, err := os.Open(mod.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return
+	}
+	v := hex.EncodeToString(h.Sum(nil))
+	mod.version.Store(&v)
+}
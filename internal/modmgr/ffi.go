@@ -10,26 +10,152 @@ package modmgr
 import "C"
 
 import (
+	"omnirouter/internal/logger"
 	"omnirouter/internal/router"
 	"unsafe"
 )
 
 //export or_register_http
 func or_register_http(muid C.muid_t, method_mask C.or_method_t, path *C.char, handler C.or_http_handler_t, extra unsafe.Pointer) C.uint64_t {
-	goPath := C.GoString(path)
 	mod := MUID2Module(MUID(muid))
 	if mod == nil {
 		return C.uint64_t(1)
 	}
-	return C.uint64_t(router.GetHTTPRouter().Register(mod.capabilities, uint8(method_mask), goPath, cHandler{fn: handler, extra: extra}))
+	goPath := router.JoinMount(mod.mount, C.GoString(path))
+	return C.uint64_t(router.GetHTTPRouter().Register(mod.capabilities, uint8(method_mask), goPath, cHandler{muid: mod.muid, fn: handler, extra: extra, mount: mod.mount, owner: moduleName(mod.filename)}))
 }
 
 //export or_unregister_http
 func or_unregister_http(muid C.muid_t, method_mask C.or_method_t, path *C.char) C.uint64_t {
-	goPath := C.GoString(path)
 	mod := MUID2Module(MUID(muid))
 	if mod == nil {
 		return C.uint64_t(1)
 	}
+	goPath := router.JoinMount(mod.mount, C.GoString(path))
 	return C.uint64_t(router.GetHTTPRouter().Unregister(mod.capabilities, uint8(method_mask), goPath))
 }
+
+//export or_register_http_ex
+func or_register_http_ex(muid C.muid_t, method_mask C.or_method_t, path *C.char, preds *C.or_predicate_t, pred_count C.uint32_t, handler C.or_http_handler_t, extra unsafe.Pointer) C.uint64_t {
+	mod := MUID2Module(MUID(muid))
+	if mod == nil {
+		return C.uint64_t(1)
+	}
+	goPath := router.JoinMount(mod.mount, C.GoString(path))
+	return C.uint64_t(router.GetHTTPRouter().RegisterGuarded(mod.capabilities, uint8(method_mask), goPath, cPredicates(preds, pred_count), cHandler{muid: mod.muid, fn: handler, extra: extra, mount: mod.mount, owner: moduleName(mod.filename)}))
+}
+
+//export or_unregister_http_ex
+func or_unregister_http_ex(muid C.muid_t, method_mask C.or_method_t, path *C.char, preds *C.or_predicate_t, pred_count C.uint32_t) C.uint64_t {
+	mod := MUID2Module(MUID(muid))
+	if mod == nil {
+		return C.uint64_t(1)
+	}
+	goPath := router.JoinMount(mod.mount, C.GoString(path))
+	return C.uint64_t(router.GetHTTPRouter().UnregisterGuarded(mod.capabilities, uint8(method_mask), goPath, cPredicates(preds, pred_count)))
+}
+
+//export or_report_health
+func or_report_health(muid C.muid_t, status C.or_health_t, detail *C.char) C.uint64_t {
+	mod := MUID2Module(MUID(muid))
+	if mod == nil {
+		return C.uint64_t(1)
+	}
+	var goDetail string
+	if detail != nil {
+		goDetail = C.GoString(detail)
+	}
+	return C.uint64_t(mod.reportHealth(uint32(status), goDetail))
+}
+
+/* The api's log functions, muid is 0 for calls from threads of the module's own */
+//export or_api_log
+func or_api_log(muid C.muid_t, level C.uint32_t, msg *C.char, module_ *C.char, request_id *C.char) {
+	var rid string
+	if request_id != nil {
+		rid = C.GoString(request_id)
+	}
+	if mod := MUID2Module(MUID(muid)); mod != nil {
+		mod.log(C.GoString(module_), uint32(level), C.GoString(msg), rid)
+		return
+	}
+	logger.Module("", C.GoString(module_), logLevel(uint32(level)), C.GoString(msg))
+}
+
+//export or_metric_new
+func or_metric_new(muid C.muid_t, kind C.or_metric_kind_t, name *C.char, help *C.char, labels **C.char, label_count C.uint32_t, buckets *C.double, bucket_count C.uint32_t) C.or_metric_t {
+	mod := MUID2Module(MUID(muid))
+	if mod == nil || name == nil {
+		return 0
+	}
+	var goHelp string
+	if help != nil {
+		goHelp = C.GoString(help)
+	}
+	var goBuckets []float64
+	if buckets != nil && bucket_count > 0 {
+		for _, b := range unsafe.Slice(buckets, int(bucket_count)) {
+			goBuckets = append(goBuckets, float64(b))
+		}
+	}
+	return C.or_metric_t(mod.newMetric(uint32(kind), C.GoString(name), goHelp, cStrings(labels, label_count), goBuckets))
+}
+
+//export or_metric_add
+func or_metric_add(muid C.muid_t, metric C.or_metric_t, value C.double, label_values **C.char, label_count C.uint32_t) C.uint64_t {
+	return cUpdateMetric(muid, METRIC_ADD, metric, value, label_values, label_count)
+}
+
+//export or_metric_set
+func or_metric_set(muid C.muid_t, metric C.or_metric_t, value C.double, label_values **C.char, label_count C.uint32_t) C.uint64_t {
+	return cUpdateMetric(muid, METRIC_SET, metric, value, label_values, label_count)
+}
+
+//export or_metric_observe
+func or_metric_observe(muid C.muid_t, metric C.or_metric_t, value C.double, label_values **C.char, label_count C.uint32_t) C.uint64_t {
+	return cUpdateMetric(muid, METRIC_OBSERVE, metric, value, label_values, label_count)
+}
+
+func cUpdateMetric(muid C.muid_t, op uint8, metric C.or_metric_t, value C.double, label_values **C.char, label_count C.uint32_t) C.uint64_t {
+	mod := MUID2Module(MUID(muid))
+	if mod == nil {
+		return C.uint64_t(1)
+	}
+	return C.uint64_t(mod.updateMetric(op, uint64(metric), float64(value), cStrings(label_values, label_count)))
+}
+
+/* NULL entries become empty strings */
+func cStrings(list **C.char, count C.uint32_t) []string {
+	if list == nil || count == 0 {
+		return nil
+	}
+
+	out := make([]string, 0, int(count))
+	for _, s := range unsafe.Slice(list, int(count)) {
+		if s == nil {
+			out = append(out, "")
+			continue
+		}
+		out = append(out, C.GoString(s))
+	}
+	return out
+}
+
+func cPredicates(preds *C.or_predicate_t, count C.uint32_t) []router.Predicate {
+	if preds == nil || count == 0 {
+		return nil
+	}
+
+	out := make([]router.Predicate, 0, int(count))
+	for _, p := range unsafe.Slice(preds, int(count)) {
+		pred := router.Predicate{Kind: router.PredicateKind(p.kind)}
+		if p.name != nil {
+			pred.Name = C.GoString(p.name)
+		}
+		if p.value != nil {
+			pred.Value = C.GoString(p.value)
+		}
+		out = append(out, pred)
+	}
+	return out
+}
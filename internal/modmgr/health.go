@@ -0,0 +1,65 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"omnirouter/internal/logger"
+	"omnirouter/internal/router"
+)
+
+/* Same values as or_health_t in cffi.h */
+type Health uint32
+
+const (
+	HEALTH_OK        Health = 0
+	HEALTH_DEGRADED  Health = 1
+	HEALTH_UNHEALTHY Health = 2
+)
+
+func (h Health) String() string {
+	switch h {
+	case HEALTH_OK:
+		return "ok"
+	case HEALTH_DEGRADED:
+		return "degraded"
+	case HEALTH_UNHEALTHY:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+type moduleHealth struct {
+	status Health
+	detail string
+}
+
+/*
+ * Health as reported by the module itself, HEALTH_OK until it says otherwise.
+ * Reset on every load, so a reloaded module starts out healthy again.
+ */
+func (mod *Module) Health() (Health, string) {
+	if h := mod.health.Load(); h != nil {
+		return h.status, h.detail
+	}
+	return HEALTH_OK, ""
+}
+
+func (mod *Module) resetHealth() {
+	mod.health.Store(nil)
+}
+
+func (mod *Module) reportHealth(status uint32, detail string) uint64 {
+	h := Health(status)
+	if h > HEALTH_UNHEALTHY {
+		logger.Warn("Invalid module health status", "module", mod.filename, "status", status)
+		return router.ERR_FFI_RESERVED
+	}
+
+	prev, prevDetail := mod.Health()
+	mod.health.Store(&moduleHealth{status: h, detail: detail})
+	if prev != h || prevDetail != detail {
+		logger.Info("Module health changed", "module", mod.filename, "health", h.String(), "detail", detail)
+	}
+	return router.SUCCESS
+}
@@ -0,0 +1,58 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/logger"
+
+	"github.com/rs/zerolog"
+)
+
+/* Log levels of the log call in the WASM and script host APIs */
+const (
+	LOG_INFO  uint32 = 0
+	LOG_WARN  uint32 = 1
+	LOG_ERROR uint32 = 2
+	LOG_FATAL uint32 = 3
+)
+
+/*
+ * Logging on behalf of a sandboxed (WASM / script) module. Unlike the C
+ * loggers, which are plain function pointers, these know the calling module
+ * and honor its logging capabilities. requestID is set for calls made while
+ * handling a request.
+ */
+func (mod *Module) hostLog(level uint32, msg string, requestID string) {
+	need := capabilities.CAP_LOGGING
+	if level == LOG_FATAL {
+		need = capabilities.CAP_LOGGING_FATAL
+	}
+	if !mod.capabilities.Has(need) {
+		logger.Warn("Module lacks the logging capability", "module", mod.filename)
+		return
+	}
+	mod.log(mod.filename, level, msg, requestID)
+}
+
+/* Subject to the module's log level, caller is what the entry shows as its origin */
+func (mod *Module) log(caller string, level uint32, msg string, requestID string) {
+	var kv []any
+	if requestID != "" {
+		kv = []any{logger.REQUEST_ID_KEY, requestID}
+	}
+	logger.Module(moduleName(mod.filename), caller, logLevel(level), msg, kv...)
+}
+
+func logLevel(level uint32) zerolog.Level {
+	switch level {
+	case LOG_WARN:
+		return zerolog.WarnLevel
+	case LOG_ERROR:
+		return zerolog.ErrorLevel
+	case LOG_FATAL:
+		return zerolog.FatalLevel
+	default:
+		return zerolog.InfoLevel
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"omnirouter/internal/logger"
+	"omnirouter/internal/metrics"
 
 	"github.com/sashka/atomicfile"
 )
@@ -45,15 +46,22 @@ func CreateModule(path string) {
 
 	filename := filepath.Base(path)
 	mod := &Module{
-		handle:   nil,
-		type_:    extensionToModuleType(filepath.Ext(filepath.Base(path))),
-		origPath: path,
-		path:     filepath.Join(mirrordir, filename),
-		filename: filename,
+		handle:       nil,
+		capabilities: moduleCapabilities(filename),
+		mount:        moduleMount(filename),
+		type_:        extensionToModuleType(filepath.Ext(filepath.Base(path))),
+		origPath:     path,
+		path:         filepath.Join(mirrordir, filename),
+		filename:     filename,
 	}
 
 	src2mod[filepath.Clean(path)] = mod
 	mirrorMu.Unlock()
+	mod.publish(EVENT_DISCOVERED)
+	if isDisabled(filename) {
+		logger.Info("Module is disabled, not staging it", "path", path)
+		return
+	}
 	mod.Stage()
 }
 
@@ -64,10 +72,15 @@ func ReloadModule(path string) {
 
 	mirrorMu.Lock()
 	mod, ok := src2mod[filepath.Clean(path)]
-	if ok {
-		err := mod.Unstage()
-		if err != nil {
-			logger.Error("Unable to unload module with", "path", path)
+	if ok && isDisabled(mod.filename) {
+		logger.Info("Module is disabled, ignoring the change", "path", path)
+		mirrorMu.Unlock()
+	} else if ok {
+		if mod.State() != MODSTATE_UNLOADED {
+			err := mod.Unstage()
+			if err != nil {
+				logger.Error("Unable to unload module with", "path", path)
+			}
 		}
 
 		mode := os.FileMode(0o644)
@@ -77,9 +90,13 @@ func ReloadModule(path string) {
 
 		if err := copyFileAtomic(mod.origPath, mod.path, mode); err != nil {
 			logger.Error("Could not copy file atomically", "src", mod.origPath, "dst", mod.path)
+		} else {
+			mod.setVersion()
+			mod.publish(EVENT_STAGED)
 		}
 
 		mod.Load()
+		metrics.ModuleReloaded(moduleName(mod.filename), mod.type_.String())
 		logger.Info("Staged module", "path", mod.path, "type", mod.type_)
 
 		mirrorMu.Unlock()
@@ -98,7 +115,9 @@ func RemoveModule(path string) {
 
 	mirrorMu.Lock()
 	mod, ok := src2mod[filepath.Clean(path)]
-	if ok {
+	if ok && mod.State() == MODSTATE_UNLOADED {
+		delete(src2mod, filepath.Clean(path))
+	} else if ok {
 		err := mod.Unstage()
 		if err == nil {
 			delete(src2mod, filepath.Clean(path))
@@ -120,6 +139,8 @@ func (mod *Module) Stage() error {
 	if err := copyFileAtomic(mod.origPath, mod.path, mode); err != nil {
 		return err
 	}
+	mod.setVersion()
+	mod.publish(EVENT_STAGED)
 
 	mod.Load()
 	logger.Info("Staged module", "path", mod.path, "type", mod.type_)
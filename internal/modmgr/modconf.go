@@ -0,0 +1,82 @@
+package modmgr
+
+import (
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	modConfMu  sync.RWMutex
+	modConfs   = make(map[string]config.ModuleConf)
+	routeConfs []config.Route
+)
+
+func SetModuleConfigs(confs map[string]config.ModuleConf) {
+	modConfMu.Lock()
+	defer modConfMu.Unlock()
+	modConfs = make(map[string]config.ModuleConf, len(confs))
+	for name, mc := range confs {
+		modConfs[name] = mc
+	}
+}
+
+func SetRouteConfigs(routes []config.Route) {
+	modConfMu.Lock()
+	defer modConfMu.Unlock()
+	routeConfs = append([]config.Route(nil), routes...)
+}
+
+/* `helloworld.so` is configured under [module.helloworld] */
+func moduleName(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+func moduleConfig(filename string) (config.ModuleConf, bool) {
+	modConfMu.RLock()
+	defer modConfMu.RUnlock()
+	mc, ok := modConfs[moduleName(filename)]
+	return mc, ok
+}
+
+/* Unconfigured modules get no capabilities at all */
+func moduleCapabilities(filename string) capabilities.Set {
+	mc, ok := moduleConfig(filename)
+	if !ok {
+		return capabilities.NewSet(capabilities.CAP_NONE)
+	}
+
+	caps, err := capabilities.ParseSet(mc.Capabilities)
+	if err != nil {
+		logger.Error("Invalid module capabilities", "module", moduleName(filename), "err", err)
+		return capabilities.NewSet(capabilities.CAP_NONE)
+	}
+	return caps
+}
+
+func moduleMount(filename string) string {
+	mc, _ := moduleConfig(filename)
+	return mc.Mount
+}
+
+func moduleSandbox(filename string) config.Sandbox {
+	mc, _ := moduleConfig(filename)
+	return mc.Sandbox
+}
+
+func moduleRoutes(filename string) []config.Route {
+	name := moduleName(filename)
+	modConfMu.RLock()
+	defer modConfMu.RUnlock()
+
+	var out []config.Route
+	for _, r := range routeConfs {
+		if r.Kind == config.ROUTE_MODULE && r.Module == name {
+			out = append(out, r)
+		}
+	}
+	return out
+}
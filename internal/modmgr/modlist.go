@@ -0,0 +1,66 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type ModuleInfo struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	MUID         string `json:"muid"`
+	State        string `json:"state"`
+	Capabilities string `json:"capabilities"`
+	Mount        string `json:"mount,omitempty"`
+	Path         string `json:"path,omitempty"`
+	Disabled     bool   `json:"disabled"`
+	Health       string `json:"health"`
+	HealthDetail string `json:"health_detail,omitempty"`
+	LoadedAt     string `json:"loaded_at,omitempty"` /* RFC 3339, only while loaded */
+	Version      string `json:"version,omitempty"`   /* SHA-256 of the staged file */
+}
+
+func (mod *Module) info() ModuleInfo {
+	var loadedAt string
+	if mod.State() == MODSTATE_LOADED {
+		loadedAt = time.Unix(0, mod.loadedAt.Load()).UTC().Format(time.RFC3339Nano)
+	}
+	health, detail := mod.Health()
+	return ModuleInfo{
+		Name:         moduleName(mod.filename),
+		Type:         mod.type_.String(),
+		MUID:         fmt.Sprintf("%016x", uint64(mod.muid)),
+		State:        mod.State().String(),
+		Capabilities: mod.capabilities.String(),
+		Mount:        mod.mount,
+		Path:         mod.origPath,
+		Disabled:     isDisabled(mod.filename),
+		Health:       health.String(),
+		HealthDetail: detail,
+		LoadedAt:     loadedAt,
+		Version:      mod.Version(),
+	}
+}
+
+/* Every known module, file based and native, sorted by name */
+func List() []ModuleInfo {
+	var out []ModuleInfo
+
+	mirrorMu.Lock()
+	for _, mod := range src2mod {
+		out = append(out, mod.info())
+	}
+	mirrorMu.Unlock()
+
+	nativeMu.Lock()
+	for _, mod := range nativeMods {
+		out = append(out, mod.info())
+	}
+	nativeMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
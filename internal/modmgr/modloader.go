@@ -11,37 +11,119 @@ package modmgr
 import "C"
 
 import (
+	"omnirouter/internal/logger"
+	"omnirouter/internal/metrics"
 	"omnirouter/internal/router"
 	"unsafe"
+
+	"github.com/valyala/fasthttp"
 )
 
 type cHandler struct {
+	muid  MUID
 	fn    C.or_http_handler_t
 	extra unsafe.Pointer
+	mount string
+	owner string
 }
 
-var _ router.HTTPHandler = cHandler{}
+var _ router.OwnedHandler = cHandler{}
+
+func (h cHandler) Owner() string { return h.owner }
 
 func (h cHandler) Invoke(ctx router.ContextPtr, req router.RequestPtr) {
+	rctx := (*fasthttp.RequestCtx)(ctx)
+	path := router.RequestPath(rctx)
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	cmount := C.CString(router.StripMount(h.mount, path))
+	defer C.free(unsafe.Pointer(cmount))
+	crid := C.CString(router.RequestID(rctx))
+	defer C.free(unsafe.Pointer(crid))
+	ctrace := C.CString(router.Traceparent(rctx))
+	defer C.free(unsafe.Pointer(ctrace))
+
+	creq := C.or_http_req_t{
+		path:        cpath,
+		mount_path:  cmount,
+		request_id:  crid,
+		traceparent: ctrace,
+	}
+
+	defer cgoCounterFor(h.owner).begin()()
 	C.call_or_http_handler(
+		C.muid_t(h.muid),
 		h.fn,
 		(*C.or_ctx_t)(ctx),
-		(*C.or_http_req_t)(req),
+		&creq,
 		h.extra,
 	)
 }
 
 func (mod *Module) Load() bool {
-	cpath := C.CString(mod.path)
-	defer C.free(unsafe.Pointer(cpath))
-
 	muid := generateMUID64(mod)
 	mod.muid = muid
-	mod.handle = C.cffi_load_module(cpath, C.muid_t(mod.muid))
+	mod.resetHealth()
+	mod.startMetrics()
+
+	if mod.type_ != MODTYPE_PROCESS && moduleSandbox(mod.filename).Enabled {
+		logger.Warn("Sandbox config ignored, only out-of-process modules can be sandboxed", "module", mod.filename)
+	}
+
+	var ok bool
+	switch mod.type_ {
+	case MODTYPE_WASM:
+		ok = mod.loadWasm()
+	case MODTYPE_SCRIPT:
+		ok = mod.loadScript()
+	case MODTYPE_NATIVE:
+		ok = mod.loadNative()
+	case MODTYPE_PROCESS:
+		/* Config routes and state are handled by the supervisor, once per child */
+		mod.setState(MODSTATE_STARTING)
+		mod.startProcess()
+		return true
+	default:
+		cpath := C.CString(mod.path)
+		defer C.free(unsafe.Pointer(cpath))
+		mod.handle = C.cffi_load_module(cpath, C.muid_t(mod.muid))
+		ok = mod.handle != nil && C.get_error() == C.LOADMOD_SUCCESS
+	}
+
+	if ok {
+		mod.registerConfigRoutes()
+		mod.setState(MODSTATE_LOADED)
+		metrics.ModuleLoaded(moduleName(mod.filename), mod.type_.String())
+		mod.publish(EVENT_LOADED)
+	} else {
+		mod.setState(MODSTATE_FAILED)
+		metrics.ModuleFailed(moduleName(mod.filename), mod.type_.String())
+		mod.publish(EVENT_INIT_FAILED)
+	}
 	return true
 }
 
-func (mod Module) Unload() bool {
-	C.cffi_unload_module(mod.handle, C.muid_t(mod.muid))
+func (mod *Module) Unload() bool {
+	mod.unregisterConfigRoutes()
+	switch mod.type_ {
+	case MODTYPE_WASM:
+		mod.unloadWasm()
+	case MODTYPE_SCRIPT:
+		mod.unloadScript()
+	case MODTYPE_PROCESS:
+		mod.stopProcess()
+	case MODTYPE_NATIVE:
+		mod.unloadNative()
+	default:
+		/* Nothing was opened if dlopen failed */
+		if mod.handle != nil {
+			C.cffi_unload_module(mod.handle, C.muid_t(mod.muid), C.bool(mod.State() == MODSTATE_LOADED))
+			mod.handle = nil
+		}
+	}
+	mod.stopMetrics()
+	mod.setState(MODSTATE_UNLOADED)
+	metrics.ModuleUnloaded(moduleName(mod.filename), mod.type_.String())
+	mod.publish(EVENT_UNLOADED)
 	return true
 }
@@ -0,0 +1,73 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/metrics"
+	"omnirouter/internal/router"
+)
+
+/* Update operations of the out-of-process PROC_METRIC frame */
+const (
+	METRIC_ADD     uint8 = 1
+	METRIC_SET     uint8 = 2
+	METRIC_OBSERVE uint8 = 3
+)
+
+/* A fresh metric set per load, the previous one (if any) is dropped */
+func (mod *Module) startMetrics() {
+	if old := mod.metricSet.Swap(metrics.NewModuleSet(moduleName(mod.filename))); old != nil {
+		old.Unregister()
+	}
+}
+
+func (mod *Module) stopMetrics() {
+	if old := mod.metricSet.Swap(nil); old != nil {
+		old.Unregister()
+	}
+}
+
+/* Returns the metric handle, 0 if the metric could not be created */
+func (mod *Module) newMetric(kind uint32, name, help string, labels []string, buckets []float64) uint64 {
+	if !mod.capabilities.Has(capabilities.CAP_METRICS) {
+		logger.Warn("Module lacks the metrics capability", "module", mod.filename)
+		return 0
+	}
+
+	set := mod.metricSet.Load()
+	if set == nil {
+		return 0
+	}
+	id, err := set.New(metrics.Kind(kind), name, help, labels, buckets)
+	if err != nil {
+		logger.Warn("Module metric could not be created", "module", mod.filename, "metric", name, "err", err.Error())
+		return 0
+	}
+	return id
+}
+
+func (mod *Module) updateMetric(op uint8, id uint64, v float64, labelValues []string) uint64 {
+	set := mod.metricSet.Load()
+	if set == nil {
+		return router.ERR_METRIC
+	}
+
+	var err error
+	switch op {
+	case METRIC_ADD:
+		err = set.Add(id, v, labelValues)
+	case METRIC_SET:
+		err = set.Set(id, v, labelValues)
+	case METRIC_OBSERVE:
+		err = set.Observe(id, v, labelValues)
+	default:
+		return router.ERR_METRIC
+	}
+	if err != nil {
+		logger.Warn("Module metric update failed", "module", mod.filename, "metric", id, "err", err.Error())
+		return router.ERR_METRIC
+	}
+	return router.SUCCESS
+}
@@ -10,7 +10,11 @@ import "C"
 
 import (
 	"omnirouter/internal/capabilities"
+	"omnirouter/internal/metrics"
+	"omnirouter/pkg/omnimod"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 )
 
 type Modtype int
@@ -18,8 +22,54 @@ type Modtype int
 const (
 	MODTYPE_UNKNOWN Modtype = 0
 	MODTYPE_DYNLIB  Modtype = 1
+	MODTYPE_WASM    Modtype = 2
+	MODTYPE_SCRIPT  Modtype = 3
+	MODTYPE_PROCESS Modtype = 4
+	MODTYPE_NATIVE  Modtype = 5
 )
 
+func (t Modtype) String() string {
+	switch t {
+	case MODTYPE_DYNLIB:
+		return "dynlib"
+	case MODTYPE_WASM:
+		return "wasm"
+	case MODTYPE_SCRIPT:
+		return "script"
+	case MODTYPE_PROCESS:
+		return "process"
+	case MODTYPE_NATIVE:
+		return "native"
+	default:
+		return "unknown"
+	}
+}
+
+type ModState int32
+
+const (
+	MODSTATE_UNLOADED   ModState = 0
+	MODSTATE_LOADED     ModState = 1
+	MODSTATE_FAILED     ModState = 2 /* init failed or the module could not be opened */
+	MODSTATE_RESTARTING ModState = 3 /* out-of-process worker exited, waiting for the restart */
+	MODSTATE_STARTING   ModState = 4 /* out-of-process worker launched, its init has not returned yet */
+)
+
+func (st ModState) String() string {
+	switch st {
+	case MODSTATE_LOADED:
+		return "loaded"
+	case MODSTATE_FAILED:
+		return "failed"
+	case MODSTATE_RESTARTING:
+		return "restarting"
+	case MODSTATE_STARTING:
+		return "starting"
+	default:
+		return "unloaded"
+	}
+}
+
 type ModuleI interface {
 	Load() bool
 	Unload() bool
@@ -29,8 +79,19 @@ type ModuleI interface {
 
 type Module struct {
 	handle       C.mod_handle_t
-	capabilities capabilities.Capabilities
+	wasm         *wasmModule
+	script       *scriptModule
+	proc         *procModule
+	native       omnimod.Module
+	state        atomic.Int32
+	loadedAt     atomic.Int64 /* unix nanoseconds of the last transition to loaded */
+	health       atomic.Pointer[moduleHealth]
+	metricSet    atomic.Pointer[metrics.ModuleSet]
+	version      atomic.Pointer[string] /* see Event */
+	capabilities capabilities.Set
 	muid         MUID
+	mount        string
+	routes       []configRoute
 	type_        Modtype
 	path         string
 	origPath     string
@@ -41,6 +102,12 @@ func extensionToModuleType(ext string) Modtype {
 	switch ext {
 	case ".so", ".dll", ".dylib":
 		return MODTYPE_DYNLIB
+	case ".wasm":
+		return MODTYPE_WASM
+	case ".lua":
+		return MODTYPE_SCRIPT
+	case ".proc":
+		return MODTYPE_PROCESS
 	default:
 		return MODTYPE_UNKNOWN
 	}
@@ -49,3 +116,14 @@ func extensionToModuleType(ext string) Modtype {
 func IsModuleFile(filename string) bool {
 	return extensionToModuleType(filepath.Ext(filename)) != MODTYPE_UNKNOWN
 }
+
+func (mod *Module) State() ModState {
+	return ModState(mod.state.Load())
+}
+
+func (mod *Module) setState(st ModState) {
+	if st == MODSTATE_LOADED {
+		mod.loadedAt.Store(time.Now().UnixNano())
+	}
+	mod.state.Store(int32(st))
+}
@@ -0,0 +1,170 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"omnirouter/internal/logger"
+	"omnirouter/internal/router"
+	"omnirouter/pkg/omnimod"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	nativeMu   sync.Mutex
+	nativeMods []*Module
+)
+
+/*
+ * Loads the modules registered through omnimod.Register. They are never
+ * staged or reloaded, but otherwise follow the same path as dynamic modules
+ * (MUID, capabilities, mount, config routes, state). Call once the module
+ * and route configs are set.
+ */
+func LoadNativeModules() {
+	nativeMu.Lock()
+	defer nativeMu.Unlock()
+
+	for _, reg := range omnimod.Registered() {
+		mod := &Module{
+			native:       reg.Module,
+			capabilities: moduleCapabilities(reg.Name),
+			mount:        moduleMount(reg.Name),
+			type_:        MODTYPE_NATIVE,
+			filename:     reg.Name,
+		}
+		mod.publish(EVENT_DISCOVERED)
+		mod.Load()
+		nativeMods = append(nativeMods, mod)
+		logger.Info("Loaded native module", "module", reg.Name, "state", mod.State().String())
+	}
+}
+
+type goAPI struct {
+	mod *Module
+}
+
+var _ omnimod.API = goAPI{}
+
+func (mod *Module) loadNative() bool {
+	if !mod.native.Init(goAPI{mod: mod}) {
+		logger.Warn("Init function returned false (failed state)", "module", mod.filename)
+		return false
+	}
+	return true
+}
+
+func (mod *Module) unloadNative() {
+	/* Uninit pairs with a successful Init only */
+	if mod.State() != MODSTATE_LOADED {
+		return
+	}
+	if !mod.native.Uninit(goAPI{mod: mod}) {
+		logger.Warn("Uninit function returned false", "module", mod.filename)
+	}
+}
+
+/* The handler named by a [[routes]] entry, if the module is a HandlerProvider */
+func (mod *Module) nativeHandler(name string) router.HTTPHandler {
+	hp, ok := mod.native.(omnimod.HandlerProvider)
+	if !ok {
+		return nil
+	}
+	fn, ok := hp.Handler(name)
+	if !ok || fn == nil {
+		return nil
+	}
+	return goHandler{fn: fn, mount: mod.mount, owner: moduleName(mod.filename)}
+}
+
+type goHandler struct {
+	fn    omnimod.Handler
+	mount string
+	owner string
+}
+
+var _ router.OwnedHandler = goHandler{}
+
+func (h goHandler) Owner() string { return h.owner }
+
+func (h goHandler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+	path := router.RequestPath(ctx)
+	h.fn(ctx, &omnimod.Request{
+		Path:        path,
+		MountPath:   router.StripMount(h.mount, path),
+		RequestID:   router.RequestID(ctx),
+		Traceparent: router.Traceparent(ctx),
+	})
+}
+
+func (a goAPI) MUID() uint64 {
+	return uint64(a.mod.muid)
+}
+
+func (a goAPI) Log(level uint32, msg string) {
+	a.mod.hostLog(level, msg, "")
+}
+
+func (a goAPI) LogRequest(req *omnimod.Request, level uint32, msg string) {
+	var rid string
+	if req != nil {
+		rid = req.RequestID
+	}
+	a.mod.hostLog(level, msg, rid)
+}
+
+func (a goAPI) ReportHealth(status uint32, detail string) uint64 {
+	return a.mod.reportHealth(status, detail)
+}
+
+func (a goAPI) NewMetric(kind uint32, name, help string, labels []string, buckets []float64) uint64 {
+	return a.mod.newMetric(kind, name, help, labels, buckets)
+}
+
+func (a goAPI) AddMetric(metric uint64, value float64, labelValues ...string) uint64 {
+	return a.mod.updateMetric(METRIC_ADD, metric, value, labelValues)
+}
+
+func (a goAPI) SetMetric(metric uint64, value float64, labelValues ...string) uint64 {
+	return a.mod.updateMetric(METRIC_SET, metric, value, labelValues)
+}
+
+func (a goAPI) ObserveMetric(metric uint64, value float64, labelValues ...string) uint64 {
+	return a.mod.updateMetric(METRIC_OBSERVE, metric, value, labelValues)
+}
+
+func (a goAPI) RegisterHTTP(methodMask uint8, path string, h omnimod.Handler) uint64 {
+	if h == nil {
+		return router.ERR_FFI_RESERVED
+	}
+	return router.GetHTTPRouter().Register(a.mod.capabilities, methodMask, router.JoinMount(a.mod.mount, path), goHandler{fn: h, mount: a.mod.mount, owner: moduleName(a.mod.filename)})
+}
+
+func (a goAPI) UnregisterHTTP(methodMask uint8, path string) uint64 {
+	return router.GetHTTPRouter().Unregister(a.mod.capabilities, methodMask, router.JoinMount(a.mod.mount, path))
+}
+
+func (a goAPI) RegisterHTTPEx(methodMask uint8, path string, preds []omnimod.Predicate, h omnimod.Handler) uint64 {
+	if h == nil {
+		return router.ERR_FFI_RESERVED
+	}
+	return router.GetHTTPRouter().RegisterGuarded(a.mod.capabilities, methodMask, router.JoinMount(a.mod.mount, path), goPredicates(preds), goHandler{fn: h, mount: a.mod.mount, owner: moduleName(a.mod.filename)})
+}
+
+func (a goAPI) UnregisterHTTPEx(methodMask uint8, path string, preds []omnimod.Predicate) uint64 {
+	return router.GetHTTPRouter().UnregisterGuarded(a.mod.capabilities, methodMask, router.JoinMount(a.mod.mount, path), goPredicates(preds))
+}
+
+/* The kinds share their values with the router's, like the C ABI's */
+func goPredicates(preds []omnimod.Predicate) []router.Predicate {
+	if len(preds) == 0 {
+		return nil
+	}
+	out := make([]router.Predicate, len(preds))
+	for i, p := range preds {
+		out[i] = router.Predicate{Kind: router.PredicateKind(p.Kind), Name: p.Name, Value: p.Value}
+	}
+	return out
+}
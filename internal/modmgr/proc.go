@@ -0,0 +1,719 @@
+//go:build cgo
+
+package modmgr
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/bridges
+#include "bridges/cffi.h"
+*/
+import "C"
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/metrics"
+	"omnirouter/internal/router"
+	"omnirouter/internal/sandbox"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ * Out-of-process modules: `.proc` executables (any language, a shebang
+ * script is fine) spawned as a child that connects back to the Unix socket
+ * named in $OMNIROUTER_SOCKET. A crashing child only takes its own routes
+ * down; it is restarted with exponential backoff.
+ *
+ * Every frame is a 13 byte header followed by the payload:
+ *
+ *   u32 payload length | u8 type | u64 id
+ *
+ * All integers are big endian, `str` is a u32 length followed by the bytes,
+ * `preds` is a u32 count of (u8 kind, str name, str value). Replies carry the
+ * id of the frame they answer, both sides number their own requests.
+ *
+ *   host -> child  PROC_INIT           u32 version                        -> PROC_RESULT
+ *   host -> child  PROC_UNINIT                                            -> PROC_RESULT
+ *   host -> child  PROC_REQUEST        str handler, u64 extra, str method,
+ *                                      str path, str mount_path, str query,
+ *                                      u32 n, n x (str name, str value),
+ *                                      str body, str request_id,
+ *                                      str traceparent                    -> PROC_RESPONSE
+ *   child -> host  PROC_RESPONSE       u16 status, u32 n, n x (str, str),
+ *                                      str body
+ *   child -> host  PROC_LOG            u8 level, str msg
+ *   child -> host  PROC_REGISTER       u8 mask, str path, str handler,
+ *                                      u64 extra                          -> PROC_RESULT
+ *   child -> host  PROC_UNREGISTER     u8 mask, str path                  -> PROC_RESULT
+ *   child -> host  PROC_REGISTER_EX    u8 mask, str path, preds,
+ *                                      str handler, u64 extra             -> PROC_RESULT
+ *   child -> host  PROC_UNREGISTER_EX  u8 mask, str path, preds           -> PROC_RESULT
+ *   child -> host  PROC_HEALTH         u8 status, str detail
+ *   child -> host  PROC_METRIC_NEW     u8 kind, str name, str help,
+ *                                      u32 n, n x str label,
+ *                                      u32 m, m x f64 bucket              -> PROC_RESULT (metric, 0 on failure)
+ *   child -> host  PROC_METRIC         u8 op (1 add, 2 set, 3 observe),
+ *                                      u64 metric, f64 value,
+ *                                      u32 n, n x str label value
+ *   either         PROC_RESULT         u64 code (non-zero init result = success)
+ *
+ * Like the other sandboxed module types, handlers are referenced by name and
+ * get the `extra` given at registration back with every request. A PROC_LOG
+ * sent with the id of a PROC_REQUEST being handled is logged with that
+ * request's ID.
+ */
+const (
+	PROC_INIT          uint8 = 1
+	PROC_UNINIT        uint8 = 2
+	PROC_REQUEST       uint8 = 3
+	PROC_RESPONSE      uint8 = 4
+	PROC_RESULT        uint8 = 5
+	PROC_LOG           uint8 = 6
+	PROC_REGISTER      uint8 = 7
+	PROC_UNREGISTER    uint8 = 8
+	PROC_REGISTER_EX   uint8 = 9
+	PROC_UNREGISTER_EX uint8 = 10
+	PROC_HEALTH        uint8 = 11
+	PROC_METRIC_NEW    uint8 = 12
+	PROC_METRIC        uint8 = 13
+)
+
+const (
+	procHeaderSize  = 13
+	procMaxFrame    = 64 << 20
+	procConnectWait = 10 * time.Second
+	procCallTimeout = 30 * time.Second
+	procStopTimeout = 5 * time.Second
+	procMinBackoff  = 100 * time.Millisecond
+	procMaxBackoff  = 30 * time.Second
+	procStableAfter = 10 * time.Second
+)
+
+/* Environment of the child */
+const (
+	PROC_SOCKET_ENV  = "OMNIROUTER_SOCKET"
+	PROC_VERSION_ENV = "OMNIROUTER_MODLOADER_VERSION"
+)
+
+var (
+	errProcClosed  = errors.New("module process connection closed")
+	errProcTimeout = errors.New("module process did not answer in time")
+)
+
+type procFrame struct {
+	typ     uint8
+	id      uint64
+	payload []byte
+}
+
+/* A route the child registered, dropped again when the child goes away */
+type procRoute struct {
+	methodMask uint8
+	path       string
+	preds      []router.Predicate
+	guarded    bool
+}
+
+type procModule struct {
+	mod  *Module
+	stop chan struct{}
+	done chan struct{}
+	cur  atomic.Pointer[procConn]
+
+	mu    sync.Mutex
+	owned []procRoute
+}
+
+type procConn struct {
+	conn   net.Conn
+	wmu    sync.Mutex
+	nextID atomic.Uint64
+	closed chan struct{}
+
+	mu       sync.Mutex
+	pending  map[uint64]chan procFrame
+	requests map[uint64]string /* request IDs of in-flight PROC_REQUESTs */
+}
+
+type procHandler struct {
+	proc  *procModule
+	name  string
+	extra uint64
+	mount string
+	owner string
+}
+
+var _ router.OwnedHandler = procHandler{}
+
+func (h procHandler) Owner() string { return h.owner }
+
+func (h procHandler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+	pc := h.proc.cur.Load()
+	if pc == nil {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		return
+	}
+
+	path := router.RequestPath(ctx)
+	var e procEncoder
+	e.str(h.name)
+	e.u64(h.extra)
+	e.bytes(ctx.Method())
+	e.str(path)
+	e.str(router.StripMount(h.mount, path))
+	e.bytes(ctx.URI().QueryString())
+	e.u32(uint32(ctx.Request.Header.Len()))
+	for k, v := range ctx.Request.Header.All() {
+		e.bytes(k)
+		e.bytes(v)
+	}
+	e.bytes(ctx.Request.Body())
+	e.str(router.RequestID(ctx))
+	e.str(router.Traceparent(ctx))
+
+	resp, err := pc.call(PROC_REQUEST, e.buf, procCallTimeout, router.RequestID(ctx))
+	if err != nil {
+		logger.ErrorContext(ctx, "Module process request failed", "module", h.proc.mod.filename, "handler", h.name, "err", err.Error())
+		if errors.Is(err, errProcTimeout) {
+			ctx.SetStatusCode(fasthttp.StatusGatewayTimeout)
+		} else {
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		}
+		return
+	}
+
+	d := procDecoder{b: resp.payload}
+	status := int(d.u16())
+	n := d.u32()
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		k, v := d.str(), d.str()
+		ctx.Response.Header.Add(k, v)
+	}
+	body := d.bytes()
+	if d.err != nil || resp.typ != PROC_RESPONSE || status < 100 || status > 999 {
+		logger.ErrorContext(ctx, "Malformed module process response", "module", h.proc.mod.filename, "handler", h.name, "status", status)
+		ctx.Response.Reset()
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		return
+	}
+	ctx.SetStatusCode(status)
+	ctx.SetBody(body)
+}
+
+/* The handler named by a [[routes]] entry, the child is trusted to know it */
+func (mod *Module) procHandler(name string) router.HTTPHandler {
+	if mod.proc == nil {
+		return nil
+	}
+	return procHandler{proc: mod.proc, name: name, mount: mod.mount, owner: moduleName(mod.filename)}
+}
+
+func (mod *Module) startProcess() {
+	/* A supervisor left running would keep its child alive with nothing pointing to it */
+	mod.stopProcess()
+	p := &procModule{mod: mod, stop: make(chan struct{}), done: make(chan struct{})}
+	mod.proc = p
+	go p.supervise()
+}
+
+func (mod *Module) stopProcess() {
+	if mod.proc == nil {
+		return
+	}
+	close(mod.proc.stop)
+	<-mod.proc.done
+	mod.proc = nil
+}
+
+func (p *procModule) supervise() {
+	defer close(p.done)
+	backoff := procMinBackoff
+	for {
+		started := time.Now()
+		err := p.run()
+
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		p.mod.setState(MODSTATE_RESTARTING)
+		if time.Since(started) >= procStableAfter {
+			backoff = procMinBackoff
+		}
+		logger.Error("Module process exited, restarting", "module", p.mod.filename, "err", fmt.Sprint(err), "backoff", backoff.String())
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, procMaxBackoff)
+		metrics.ModuleReloaded(moduleName(p.mod.filename), p.mod.type_.String())
+	}
+}
+
+/* One child lifetime, returns once it exited or p.stop was closed */
+func (p *procModule) run() error {
+	dir, err := os.MkdirTemp("", "omnirouter-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "module.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	cmd, err := sandbox.Command(p.mod.path, moduleSandbox(p.mod.filename))
+	if err != nil {
+		return err
+	}
+	/* The router's environment, or the sandbox's minimal one */
+	cmd.Env = append(cmd.Environ(),
+		PROC_SOCKET_ENV+"="+sock,
+		PROC_VERSION_ENV+"="+strconv.Itoa(int(C.MODLOADER_VERSION)),
+	)
+	cmd.Stdout = &procLogWriter{mod: p.mod}
+	cmd.Stderr = &procLogWriter{mod: p.mod, warn: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	pc, err := p.accept(ln.(*net.UnixListener), exited)
+	if err != nil {
+		p.kill(cmd, exited)
+		return err
+	}
+	defer p.teardown(pc)
+
+	go p.serve(pc)
+
+	p.mod.resetHealth()
+	res, err := pc.call(PROC_INIT, binary.BigEndian.AppendUint32(nil, uint32(C.MODLOADER_VERSION)), procConnectWait, "")
+	if err != nil || procResult(res) == 0 {
+		logger.Warn("Init function returned false (failed state)", "path", p.mod.path, "err", err)
+		metrics.ModuleFailed(moduleName(p.mod.filename), p.mod.type_.String())
+		p.mod.publish(EVENT_INIT_FAILED)
+		p.kill(cmd, exited)
+		return fmt.Errorf("init failed")
+	}
+
+	p.cur.Store(pc)
+	p.mod.setState(MODSTATE_LOADED)
+	metrics.ModuleLoaded(moduleName(p.mod.filename), p.mod.type_.String())
+	p.mu.Lock()
+	for _, r := range p.mod.addConfigRoutes() {
+		p.owned = append(p.owned, procRoute{methodMask: r.methodMask, path: r.path})
+	}
+	p.mu.Unlock()
+	p.mod.publish(EVENT_LOADED)
+	logger.Info("Module process started", "module", p.mod.filename, "pid", cmd.Process.Pid)
+
+	select {
+	case err := <-exited:
+		return err
+	case <-pc.closed:
+		p.kill(cmd, exited)
+		return errProcClosed
+	case <-p.stop:
+		p.cur.Store(nil)
+		if _, err := pc.call(PROC_UNINIT, nil, procStopTimeout, ""); err != nil {
+			logger.Error("Module process uninit failed", "module", p.mod.filename, "err", err.Error())
+		}
+		p.kill(cmd, exited)
+		return nil
+	}
+}
+
+func (p *procModule) accept(ln *net.UnixListener, exited chan error) (*procConn, error) {
+	type accepted struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan accepted, 1)
+	ln.SetDeadline(time.Now().Add(procConnectWait))
+	go func() {
+		conn, err := ln.Accept()
+		ch <- accepted{conn, err}
+	}()
+
+	select {
+	case a := <-ch:
+		if a.err != nil {
+			return nil, fmt.Errorf("module process did not connect: %w", a.err)
+		}
+		return &procConn{conn: a.conn, closed: make(chan struct{}), pending: make(map[uint64]chan procFrame), requests: make(map[uint64]string)}, nil
+	case err := <-exited:
+		exited <- err
+		return nil, fmt.Errorf("module process exited before connecting: %v", err)
+	case <-p.stop:
+		return nil, errProcClosed
+	}
+}
+
+/* SIGTERM first, SIGKILL if the child does not exit in time */
+func (p *procModule) kill(cmd *exec.Cmd, exited chan error) {
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(procStopTimeout):
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+/* Drops everything the child registered, its handlers are gone */
+func (p *procModule) teardown(pc *procConn) {
+	p.cur.Store(nil)
+	pc.conn.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.owned {
+		if r.guarded {
+			router.GetHTTPRouter().UnregisterGuarded(systemCaps, r.methodMask, r.path, r.preds)
+		} else {
+			router.GetHTTPRouter().Unregister(systemCaps, r.methodMask, r.path)
+		}
+	}
+	p.owned = nil
+}
+
+/* Reads frames until the connection breaks, then fails everything pending */
+func (p *procModule) serve(pc *procConn) {
+	defer func() {
+		close(pc.closed)
+		pc.mu.Lock()
+		for id, ch := range pc.pending {
+			close(ch)
+			delete(pc.pending, id)
+		}
+		pc.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(pc.conn)
+	for {
+		f, err := readProcFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Error("Invalid frame from module process", "module", p.mod.filename, "err", err.Error())
+			}
+			return
+		}
+
+		switch f.typ {
+		case PROC_RESULT, PROC_RESPONSE:
+			pc.mu.Lock()
+			ch, ok := pc.pending[f.id]
+			delete(pc.pending, f.id)
+			pc.mu.Unlock()
+			if ok {
+				ch <- f
+			}
+		case PROC_LOG:
+			d := procDecoder{b: f.payload}
+			level, msg := d.u8(), d.str()
+			pc.mu.Lock()
+			rid := pc.requests[f.id]
+			pc.mu.Unlock()
+			if d.err == nil {
+				p.mod.hostLog(uint32(level), msg, rid)
+			}
+		case PROC_METRIC:
+			d := procDecoder{b: f.payload}
+			op, id, v, values := d.u8(), d.u64(), d.f64(), d.strs()
+			if d.err == nil {
+				p.mod.updateMetric(op, id, v, values)
+			}
+		case PROC_METRIC_NEW:
+			d := procDecoder{b: f.payload}
+			kind, name, help, labels := d.u8(), d.str(), d.str(), d.strs()
+			var buckets []float64
+			for n := d.u32(); n > 0 && d.err == nil; n-- {
+				buckets = append(buckets, d.f64())
+			}
+			var id uint64
+			if d.err == nil {
+				id = p.mod.newMetric(uint32(kind), name, help, labels, buckets)
+			} else {
+				logger.Error("Malformed call from module process", "module", p.mod.filename, "type", f.typ)
+			}
+			if err := pc.write(PROC_RESULT, f.id, binary.BigEndian.AppendUint64(nil, id)); err != nil {
+				return
+			}
+		case PROC_HEALTH:
+			d := procDecoder{b: f.payload}
+			status, detail := d.u8(), d.str()
+			if d.err == nil {
+				p.mod.reportHealth(uint32(status), detail)
+			}
+		default:
+			code := p.handleCall(f)
+			if err := pc.write(PROC_RESULT, f.id, binary.BigEndian.AppendUint64(nil, code)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+/* API calls made by the child, checked against the module's capabilities */
+func (p *procModule) handleCall(f procFrame) uint64 {
+	mod := p.mod
+	d := procDecoder{b: f.payload}
+	mask := d.u8()
+	path := router.JoinMount(mod.mount, d.str())
+
+	var preds []router.Predicate
+	if f.typ == PROC_REGISTER_EX || f.typ == PROC_UNREGISTER_EX {
+		preds = d.preds()
+	}
+
+	var h router.HTTPHandler
+	if f.typ == PROC_REGISTER || f.typ == PROC_REGISTER_EX {
+		h = procHandler{proc: p, name: d.str(), extra: d.u64(), mount: mod.mount, owner: moduleName(mod.filename)}
+	}
+	if d.err != nil {
+		logger.Error("Malformed call from module process", "module", mod.filename, "type", f.typ)
+		return router.ERR_FFI_RESERVED
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch f.typ {
+	case PROC_REGISTER:
+		code := router.GetHTTPRouter().Register(mod.capabilities, mask, path, h)
+		if code == router.SUCCESS {
+			p.owned = append(p.owned, procRoute{methodMask: mask, path: path})
+		}
+		return code
+	case PROC_REGISTER_EX:
+		code := router.GetHTTPRouter().RegisterGuarded(mod.capabilities, mask, path, preds, h)
+		if code == router.SUCCESS {
+			p.owned = append(p.owned, procRoute{methodMask: mask, path: path, preds: preds, guarded: true})
+		}
+		return code
+	case PROC_UNREGISTER:
+		return router.GetHTTPRouter().Unregister(mod.capabilities, mask, path)
+	case PROC_UNREGISTER_EX:
+		return router.GetHTTPRouter().UnregisterGuarded(mod.capabilities, mask, path, preds)
+	}
+
+	logger.Error("Unknown frame type from module process", "module", mod.filename, "type", f.typ)
+	return router.ERR_FFI_RESERVED
+}
+
+/* requestID is set for PROC_REQUEST, log frames sent with its id are attributed to it */
+func (pc *procConn) call(typ uint8, payload []byte, timeout time.Duration, requestID string) (procFrame, error) {
+	id := pc.nextID.Add(1)
+	ch := make(chan procFrame, 1)
+	pc.mu.Lock()
+	pc.pending[id] = ch
+	if requestID != "" {
+		pc.requests[id] = requestID
+		defer func() {
+			pc.mu.Lock()
+			delete(pc.requests, id)
+			pc.mu.Unlock()
+		}()
+	}
+	pc.mu.Unlock()
+
+	if err := pc.write(typ, id, payload); err != nil {
+		pc.mu.Lock()
+		delete(pc.pending, id)
+		pc.mu.Unlock()
+		return procFrame{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return procFrame{}, errProcClosed
+		}
+		return f, nil
+	case <-timer.C:
+		pc.mu.Lock()
+		delete(pc.pending, id)
+		pc.mu.Unlock()
+		return procFrame{}, errProcTimeout
+	}
+}
+
+func (pc *procConn) write(typ uint8, id uint64, payload []byte) error {
+	var hdr [procHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(payload)))
+	hdr[4] = typ
+	binary.BigEndian.PutUint64(hdr[5:13], id)
+
+	pc.wmu.Lock()
+	defer pc.wmu.Unlock()
+	if _, err := pc.conn.Write(append(hdr[:], payload...)); err != nil {
+		return errProcClosed
+	}
+	return nil
+}
+
+func readProcFrame(r io.Reader) (procFrame, error) {
+	var hdr [procHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return procFrame{}, err
+	}
+	n := binary.BigEndian.Uint32(hdr[0:4])
+	if n > procMaxFrame {
+		return procFrame{}, fmt.Errorf("frame of %d bytes exceeds the limit", n)
+	}
+	f := procFrame{typ: hdr[4], id: binary.BigEndian.Uint64(hdr[5:13]), payload: make([]byte, n)}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return procFrame{}, err
+	}
+	return f, nil
+}
+
+func procResult(f procFrame) uint64 {
+	d := procDecoder{b: f.payload}
+	code := d.u64()
+	if f.typ != PROC_RESULT || d.err != nil {
+		return 0
+	}
+	return code
+}
+
+/* Child stdout/stderr go to our log, one entry per line */
+type procLogWriter struct {
+	mod  *Module
+	warn bool
+	buf  []byte
+}
+
+func (w *procLogWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := 0
+		for i < len(w.buf) && w.buf[i] != '\n' {
+			i++
+		}
+		if i == len(w.buf) {
+			return len(b), nil
+		}
+		line := string(w.buf[:i])
+		w.buf = w.buf[i+1:]
+		if w.warn {
+			logger.Module(moduleName(w.mod.filename), w.mod.filename, zerolog.WarnLevel, line, "stream", "stderr")
+		} else {
+			logger.Module(moduleName(w.mod.filename), w.mod.filename, zerolog.InfoLevel, line, "stream", "stdout")
+		}
+	}
+}
+
+type procEncoder struct {
+	buf []byte
+}
+
+func (e *procEncoder) u32(v uint32) { e.buf = binary.BigEndian.AppendUint32(e.buf, v) }
+func (e *procEncoder) u64(v uint64) { e.buf = binary.BigEndian.AppendUint64(e.buf, v) }
+func (e *procEncoder) str(s string) { e.u32(uint32(len(s))); e.buf = append(e.buf, s...) }
+func (e *procEncoder) bytes(b []byte) {
+	e.u32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+/* Sticky error, reads after a short one return zero values */
+type procDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *procDecoder) take(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	out := d.b[:n]
+	d.b = d.b[n:]
+	return out
+}
+
+func (d *procDecoder) u8() uint8 {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *procDecoder) u16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *procDecoder) u32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *procDecoder) u64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *procDecoder) bytes() []byte {
+	return d.take(int(d.u32()))
+}
+
+func (d *procDecoder) str() string {
+	return string(d.bytes())
+}
+
+func (d *procDecoder) strs() []string {
+	n := d.u32()
+	var out []string
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		out = append(out, d.str())
+	}
+	return out
+}
+
+func (d *procDecoder) f64() float64 {
+	return math.Float64frombits(d.u64())
+}
+
+func (d *procDecoder) preds() []router.Predicate {
+	n := d.u32()
+	var out []router.Predicate
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		out = append(out, router.Predicate{Kind: router.PredicateKind(d.u8()), Name: d.str(), Value: d.str()})
+	}
+	return out
+}
@@ -0,0 +1,439 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"fmt"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/metrics"
+	"omnirouter/internal/router"
+	"os"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+/*
+ * Lua script modules. The script is compiled once and every VM of the
+ * module's pool runs its top level chunk, but `init`/`uninit` only run on
+ * the first one; globals are therefore not shared between requests and
+ * should be treated as read-only configuration.
+ *
+ * The host API lives in the global `omnirouter` table and mirrors or_api_t:
+ *
+ *   omnirouter.log_info(msg), log_warn, log_error, log_fatal
+ *   omnirouter.register_http(method_mask, path, handler_name [, extra])
+ *   omnirouter.unregister_http(method_mask, path)
+ *   omnirouter.register_http_ex(method_mask, path, preds, handler_name [, extra])
+ *   omnirouter.unregister_http_ex(method_mask, path, preds)
+ *   omnirouter.report_health(omnirouter.HEALTH_*, detail)
+ *   omnirouter.metric_new(omnirouter.METRIC_*, name, help [, labels [, buckets]])
+ *   omnirouter.metric_add(metric, value [, label_values]), metric_set, metric_observe
+ *
+ * with `preds` a list of {kind = omnirouter.PRED_*, name = ..., value = ...}.
+ * Handlers are global functions called as handler(ctx, req, extra), `req`
+ * being a table with path, mount_path, method, query, body, request_id,
+ * traceparent and header(name), `ctx` offering status(code), header(name,
+ * value) and write(data). Log calls made from a handler carry the request ID.
+ */
+type scriptModule struct {
+	mod   *Module
+	proto *lua.FunctionProto
+
+	mu     sync.Mutex
+	idle   []*lua.LState
+	closed bool
+}
+
+/* Registry entry holding the ID of the request a VM is handling, for log calls */
+const luaRequestIDKey = "omnirouter.request_id"
+
+type scriptHandler struct {
+	script *scriptModule
+	name   string
+	extra  lua.LValue
+	mount  string
+	owner  string
+}
+
+var _ router.OwnedHandler = scriptHandler{}
+
+func (h scriptHandler) Owner() string { return h.owner }
+
+func (h scriptHandler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+
+	L, err := h.script.get()
+	if err != nil {
+		logger.ErrorContext(ctx, "No Lua VM available", "module", h.script.mod.filename, "err", err.Error())
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	defer h.script.put(L)
+
+	fn, ok := L.GetGlobal(h.name).(*lua.LFunction)
+	if !ok {
+		logger.ErrorContext(ctx, "Lua handler is not a function", "module", h.script.mod.filename, "handler", h.name)
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+
+	L.G.Registry.RawSetString(luaRequestIDKey, lua.LString(router.RequestID(ctx)))
+	err = L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true},
+		luaResponse(L, ctx), luaRequest(L, ctx, h.mount), h.extra)
+	L.G.Registry.RawSetString(luaRequestIDKey, lua.LNil)
+	if err != nil {
+		logger.ErrorContext(ctx, "Lua handler failed", "module", h.script.mod.filename, "handler", h.name, "err", err.Error())
+		ctx.Response.Reset()
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	}
+}
+
+func (mod *Module) loadScript() bool {
+	src, err := os.Open(mod.path)
+	if err != nil {
+		logger.Error("Invalid module path", "path", mod.path, "err", err)
+		return false
+	}
+	defer src.Close()
+
+	chunk, err := parse.Parse(src, mod.filename)
+	if err != nil {
+		logger.Error("Could not parse Lua module", "path", mod.path, "err", err)
+		return false
+	}
+	proto, err := lua.Compile(chunk, mod.filename)
+	if err != nil {
+		logger.Error("Could not compile Lua module", "path", mod.path, "err", err)
+		return false
+	}
+
+	s := &scriptModule{mod: mod, proto: proto}
+	mod.script = s
+
+	L, err := s.get()
+	if err != nil {
+		logger.Error("Could not run Lua module", "path", mod.path, "err", err)
+		return false
+	}
+	defer s.put(L)
+
+	return s.callLifecycle(L, "init")
+}
+
+func (mod *Module) unloadScript() {
+	s := mod.script
+	if s == nil {
+		return
+	}
+
+	if L, err := s.get(); err == nil {
+		s.callLifecycle(L, "uninit")
+		L.Close()
+	}
+
+	/* VMs still serving a request are closed when they are returned */
+	s.mu.Lock()
+	s.closed = true
+	for _, L := range s.idle {
+		L.Close()
+	}
+	s.idle = nil
+	s.mu.Unlock()
+}
+
+/* Calls `init`/`uninit`, which mirror the C ABI and have to return true */
+func (s *scriptModule) callLifecycle(L *lua.LState, name string) bool {
+	fn, ok := L.GetGlobal(name).(*lua.LFunction)
+	if !ok {
+		logger.Error(fmt.Sprintf("Lua module has no %q function", name), "path", s.mod.path)
+		return false
+	}
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}); err != nil {
+		logger.Error(fmt.Sprintf("Lua %s failed", name), "path", s.mod.path, "err", err.Error())
+		return false
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	if !lua.LVAsBool(ret) {
+		logger.Warn(fmt.Sprintf("%s function returned false (failed state)", name), "path", s.mod.path)
+		return false
+	}
+	return true
+}
+
+func (s *scriptModule) get() (*lua.LState, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("module unloaded")
+	}
+	if n := len(s.idle); n > 0 {
+		L := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return L, nil
+	}
+	s.mu.Unlock()
+	return s.newState()
+}
+
+func (s *scriptModule) put(L *lua.LState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		L.Close()
+		return
+	}
+	L.SetTop(0)
+	s.idle = append(s.idle, L)
+}
+
+/* Only the side effect free standard libraries, no io/os/package */
+func (s *scriptModule) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.CoroutineLibName, lua.OpenCoroutine},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("omnirouter", s.hostTable(L))
+
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	return L, nil
+}
+
+func (s *scriptModule) hostTable(L *lua.LState) *lua.LTable {
+	mod := s.mod
+	t := L.NewTable()
+
+	for name, level := range map[string]uint32{
+		"log_info":  LOG_INFO,
+		"log_warn":  LOG_WARN,
+		"log_error": LOG_ERROR,
+		"log_fatal": LOG_FATAL,
+	} {
+		L.SetField(t, name, L.NewFunction(func(L *lua.LState) int {
+			rid, _ := L.G.Registry.RawGetString(luaRequestIDKey).(lua.LString)
+			mod.hostLog(level, L.CheckString(1), string(rid))
+			return 0
+		}))
+	}
+
+	L.SetField(t, "register_http", L.NewFunction(func(L *lua.LState) int {
+		mask, path, name := uint8(L.CheckInt(1)), L.CheckString(2), L.CheckString(3)
+		h := s.handler(L, name, L.Get(4))
+		if h == nil {
+			L.Push(lua.LNumber(router.ERR_FFI_RESERVED))
+			return 1
+		}
+		L.Push(lua.LNumber(router.GetHTTPRouter().Register(mod.capabilities, mask, router.JoinMount(mod.mount, path), h)))
+		return 1
+	}))
+
+	L.SetField(t, "unregister_http", L.NewFunction(func(L *lua.LState) int {
+		mask, path := uint8(L.CheckInt(1)), L.CheckString(2)
+		L.Push(lua.LNumber(router.GetHTTPRouter().Unregister(mod.capabilities, mask, router.JoinMount(mod.mount, path))))
+		return 1
+	}))
+
+	L.SetField(t, "register_http_ex", L.NewFunction(func(L *lua.LState) int {
+		mask, path, preds, name := uint8(L.CheckInt(1)), L.CheckString(2), luaPredicates(L, 3), L.CheckString(4)
+		h := s.handler(L, name, L.Get(5))
+		if h == nil {
+			L.Push(lua.LNumber(router.ERR_FFI_RESERVED))
+			return 1
+		}
+		L.Push(lua.LNumber(router.GetHTTPRouter().RegisterGuarded(mod.capabilities, mask, router.JoinMount(mod.mount, path), preds, h)))
+		return 1
+	}))
+
+	L.SetField(t, "unregister_http_ex", L.NewFunction(func(L *lua.LState) int {
+		mask, path, preds := uint8(L.CheckInt(1)), L.CheckString(2), luaPredicates(L, 3)
+		L.Push(lua.LNumber(router.GetHTTPRouter().UnregisterGuarded(mod.capabilities, mask, router.JoinMount(mod.mount, path), preds)))
+		return 1
+	}))
+
+	L.SetField(t, "report_health", L.NewFunction(func(L *lua.LState) int {
+		status, detail := uint32(L.CheckInt(1)), L.OptString(2, "")
+		L.Push(lua.LNumber(mod.reportHealth(status, detail)))
+		return 1
+	}))
+
+	L.SetField(t, "metric_new", L.NewFunction(func(L *lua.LState) int {
+		kind, name, help := uint32(L.CheckInt(1)), L.CheckString(2), L.OptString(3, "")
+		labels := luaStrings(L.OptTable(4, nil))
+		var buckets []float64
+		if bt := L.OptTable(5, nil); bt != nil {
+			for i := 1; i <= bt.Len(); i++ {
+				buckets = append(buckets, float64(lua.LVAsNumber(bt.RawGetInt(i))))
+			}
+		}
+		L.Push(lua.LNumber(mod.newMetric(kind, name, help, labels, buckets)))
+		return 1
+	}))
+
+	for name, op := range map[string]uint8{
+		"metric_add":     METRIC_ADD,
+		"metric_set":     METRIC_SET,
+		"metric_observe": METRIC_OBSERVE,
+	} {
+		L.SetField(t, name, L.NewFunction(func(L *lua.LState) int {
+			id, v, values := uint64(L.CheckInt64(1)), float64(L.CheckNumber(2)), luaStrings(L.OptTable(3, nil))
+			L.Push(lua.LNumber(mod.updateMetric(op, id, v, values)))
+			return 1
+		}))
+	}
+
+	for name, v := range map[string]int{
+		"METHOD_GET":        int(router.METHOD_GET),
+		"METHOD_HEAD":       int(router.METHOD_HEAD),
+		"METHOD_POST":       int(router.METHOD_POST),
+		"METHOD_PUT":        int(router.METHOD_PUT),
+		"METHOD_DELETE":     int(router.METHOD_DELETE),
+		"METHOD_PATCH":      int(router.METHOD_PATCH),
+		"METHOD_OPTIONS":    int(router.METHOD_OPTIONS),
+		"METHOD_ANY":        int(router.METHOD_ANY),
+		"PRED_HEADER":       int(router.PRED_HEADER),
+		"PRED_QUERY":        int(router.PRED_QUERY),
+		"PRED_ACCEPT":       int(router.PRED_ACCEPT),
+		"PRED_CONTENT_TYPE": int(router.PRED_CONTENT_TYPE),
+		"HEALTH_OK":         int(HEALTH_OK),
+		"HEALTH_DEGRADED":   int(HEALTH_DEGRADED),
+		"HEALTH_UNHEALTHY":  int(HEALTH_UNHEALTHY),
+		"METRIC_COUNTER":    int(metrics.KIND_COUNTER),
+		"METRIC_GAUGE":      int(metrics.KIND_GAUGE),
+		"METRIC_HISTOGRAM":  int(metrics.KIND_HISTOGRAM),
+	} {
+		L.SetField(t, name, lua.LNumber(v))
+	}
+	return t
+}
+
+/*
+ * Handlers run on whichever VM of the pool is free, so they are looked up by
+ * global name and `extra` has to be a plain value that is valid in every VM.
+ */
+func (s *scriptModule) handler(L *lua.LState, name string, extra lua.LValue) router.HTTPHandler {
+	switch extra.Type() {
+	case lua.LTNil, lua.LTBool, lua.LTNumber, lua.LTString:
+	default:
+		L.RaiseError("extra must be nil, a boolean, a number or a string")
+		return nil
+	}
+	if _, ok := L.GetGlobal(name).(*lua.LFunction); !ok {
+		logger.Error("Lua handler function not found", "module", s.mod.filename, "handler", name)
+		return nil
+	}
+	return scriptHandler{script: s, name: name, extra: extra, mount: s.mod.mount, owner: moduleName(s.mod.filename)}
+}
+
+/* The handler named by a [[routes]] entry */
+func (mod *Module) scriptHandler(name string) router.HTTPHandler {
+	s := mod.script
+	if s == nil {
+		return nil
+	}
+	L, err := s.get()
+	if err != nil {
+		return nil
+	}
+	defer s.put(L)
+	return s.handler(L, name, lua.LNil)
+}
+
+func luaPredicates(L *lua.LState, n int) []router.Predicate {
+	t := L.OptTable(n, nil)
+	if t == nil {
+		return nil
+	}
+
+	var out []router.Predicate
+	t.ForEach(func(_, v lua.LValue) {
+		p, ok := v.(*lua.LTable)
+		if !ok {
+			L.ArgError(n, "predicates must be tables")
+			return
+		}
+		out = append(out, router.Predicate{
+			Kind:  router.PredicateKind(lua.LVAsNumber(p.RawGetString("kind"))),
+			Name:  lua.LVAsString(p.RawGetString("name")),
+			Value: lua.LVAsString(p.RawGetString("value")),
+		})
+	})
+	return out
+}
+
+/* The array part of t, in order */
+func luaStrings(t *lua.LTable) []string {
+	if t == nil {
+		return nil
+	}
+	out := make([]string, 0, t.Len())
+	for i := 1; i <= t.Len(); i++ {
+		out = append(out, lua.LVAsString(t.RawGetInt(i)))
+	}
+	return out
+}
+
+/* Only valid for the duration of the handler call, like or_http_req_t */
+func luaRequest(L *lua.LState, ctx *fasthttp.RequestCtx, mount string) *lua.LTable {
+	path := router.RequestPath(ctx)
+	req := L.NewTable()
+	L.SetField(req, "path", lua.LString(path))
+	L.SetField(req, "mount_path", lua.LString(router.StripMount(mount, path)))
+	L.SetField(req, "method", lua.LString(ctx.Method()))
+	L.SetField(req, "query", lua.LString(ctx.URI().QueryString()))
+	L.SetField(req, "body", lua.LString(ctx.Request.Body()))
+	L.SetField(req, "request_id", lua.LString(router.RequestID(ctx)))
+	L.SetField(req, "traceparent", lua.LString(router.Traceparent(ctx)))
+	L.SetField(req, "header", L.NewFunction(func(L *lua.LState) int {
+		if v, ok := router.PeekHeader(&ctx.Request.Header, L.CheckString(1)); ok {
+			L.Push(lua.LString(v))
+		} else {
+			L.Push(lua.LNil)
+		}
+		return 1
+	}))
+	return req
+}
+
+/* Methods are called with `:`, the first argument is the table itself */
+func luaResponse(L *lua.LState, ctx *fasthttp.RequestCtx) *lua.LTable {
+	resp := L.NewTable()
+	L.SetField(resp, "status", L.NewFunction(func(L *lua.LState) int {
+		status := L.CheckInt(2)
+		if status < 100 || status > 999 {
+			L.ArgError(2, "status must be within 100..999")
+		}
+		ctx.SetStatusCode(status)
+		return 0
+	}))
+	L.SetField(resp, "header", L.NewFunction(func(L *lua.LState) int {
+		ctx.Response.Header.Set(L.CheckString(2), L.CheckString(3))
+		return 0
+	}))
+	L.SetField(resp, "write", L.NewFunction(func(L *lua.LState) int {
+		ctx.WriteString(L.CheckString(2))
+		return 0
+	}))
+	return resp
+}
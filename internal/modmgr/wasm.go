@@ -0,0 +1,445 @@
+//go:build cgo
+
+package modmgr
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/router"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ * WASM modules run inside their own wazero runtime, so a trapping module only
+ * fails its own requests. The host API mirrors or_api_t and is imported from
+ * the "omnirouter" module, see bridges/or_wasm.h for the guest side.
+ *
+ * Handlers are exported functions taking the `extra` value (i32), referenced
+ * by export name both in register_http and in [[routes]] `handler`. Guest
+ * code is not reentrant, so calls into one module are serialized.
+ */
+type wasmModule struct {
+	mu      sync.Mutex
+	runtime wazero.Runtime
+	inst    api.Module
+}
+
+const WASM_HOST_MODULE = "omnirouter"
+
+/* Returned by the request accessors when called outside a handler or for a missing header */
+const wasmNone = ^uint32(0)
+
+/* Per handler call state, only reachable through the call's context */
+type wasmCall struct {
+	ctx       *fasthttp.RequestCtx
+	path      string
+	mount     string
+	statusErr error /* from resp_status, fails the request once the guest returns */
+}
+
+type wasmCallKey struct{}
+
+func callFrom(ctx context.Context) *wasmCall {
+	call, _ := ctx.Value(wasmCallKey{}).(*wasmCall)
+	return call
+}
+
+type wasmHandler struct {
+	wasm  *wasmModule
+	fn    api.Function
+	name  string
+	extra uint32
+	mount string
+	owner string
+}
+
+var _ router.OwnedHandler = wasmHandler{}
+
+func (h wasmHandler) Owner() string { return h.owner }
+
+func (h wasmHandler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+	path := router.RequestPath(ctx)
+	call := &wasmCall{ctx: ctx, path: path, mount: router.StripMount(h.mount, path)}
+
+	h.wasm.mu.Lock()
+	_, err := h.fn.Call(context.WithValue(context.Background(), wasmCallKey{}, call), uint64(h.extra))
+	h.wasm.mu.Unlock()
+
+	if err != nil {
+		logger.ErrorContext(ctx, "WASM handler failed", "handler", h.name, "err", err.Error())
+		ctx.Response.Reset()
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	} else if call.statusErr != nil {
+		logger.ErrorContext(ctx, "WASM handler failed", "handler", h.name, "err", call.statusErr.Error())
+		ctx.Response.Reset()
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+	}
+}
+
+func (mod *Module) loadWasm() bool {
+	bin, err := os.ReadFile(mod.path)
+	if err != nil {
+		logger.Error("Invalid module path", "path", mod.path, "err", err)
+		return false
+	}
+
+	ctx := context.Background()
+	rt := wazero.NewRuntime(ctx)
+	wasi_snapshot_preview1.MustInstantiate(ctx, rt)
+	if _, err := mod.wasmHostModule(rt).Instantiate(ctx); err != nil {
+		logger.Error("Could not instantiate WASM host module", "path", mod.path, "err", err)
+		rt.Close(ctx)
+		return false
+	}
+
+	/* Reactor modules (wasi-sdk, TinyGo, Go c-shared) initialize in _initialize, a missing one is skipped */
+	cfg := wazero.NewModuleConfig().
+		WithName(mod.filename).
+		WithStartFunctions("_initialize").
+		WithStdout(os.Stdout).
+		WithStderr(os.Stderr)
+
+	w := &wasmModule{runtime: rt}
+	mod.wasm = w
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	inst, err := rt.InstantiateWithConfig(ctx, bin, cfg)
+	if err != nil {
+		logger.Error("Could not instantiate WASM module", "path", mod.path, "err", err)
+		return false
+	}
+	w.inst = inst
+
+	init := inst.ExportedFunction("init")
+	if init == nil {
+		logger.Error("WASM module has no \"init\" export", "path", mod.path)
+		return false
+	}
+
+	res, err := init.Call(ctx)
+	if err != nil || len(res) == 0 || uint32(res[0]) == 0 {
+		logger.Warn("Init function returned false (failed state)", "path", mod.path, "err", err)
+		return false
+	}
+	return true
+}
+
+func (mod *Module) unloadWasm() {
+	w := mod.wasm
+	if w == nil {
+		return
+	}
+
+	ctx := context.Background()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.inst != nil {
+		if uninit := w.inst.ExportedFunction("uninit"); uninit == nil {
+			logger.Error("WASM module has no \"uninit\" export", "path", mod.path)
+		} else if _, err := uninit.Call(ctx); err != nil {
+			logger.Error("WASM uninit failed", "path", mod.path, "err", err.Error())
+		}
+	}
+
+	/* Handlers the module left registered fail with 500 from here on instead of crashing */
+	if err := w.runtime.Close(ctx); err != nil {
+		logger.Error("Could not close WASM runtime", "path", mod.path, "err", err.Error())
+	}
+}
+
+/* Resolves an exported handler, which has to take exactly the i32 `extra` */
+func (mod *Module) wasmHandler(name string, extra uint32) router.HTTPHandler {
+	if mod.wasm == nil || mod.wasm.inst == nil {
+		return nil
+	}
+
+	fn := mod.wasm.inst.ExportedFunction(name)
+	if fn == nil {
+		logger.Error("Handler export not found in WASM module", "module", mod.filename, "handler", name)
+		return nil
+	}
+	def := fn.Definition()
+	if len(def.ParamTypes()) != 1 || def.ParamTypes()[0] != api.ValueTypeI32 || len(def.ResultTypes()) != 0 {
+		logger.Error("WASM handler has the wrong signature", "module", mod.filename, "handler", name)
+		return nil
+	}
+	return wasmHandler{wasm: mod.wasm, fn: fn, name: name, extra: extra, mount: mod.mount, owner: moduleName(mod.filename)}
+}
+
+func (mod *Module) wasmHostModule(rt wazero.Runtime) wazero.HostModuleBuilder {
+	b := rt.NewHostModuleBuilder(WASM_HOST_MODULE)
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, level, ptr, n uint32) {
+		msg, ok := readString(m, ptr, n)
+		if !ok {
+			return
+		}
+		var rid string
+		if call := callFrom(ctx); call != nil {
+			rid = router.RequestID(call.ctx)
+		}
+		mod.hostLog(level, msg, rid)
+	}).Export("log")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, mask, pathPtr, pathLen, namePtr, nameLen, extra uint32) uint64 {
+		path, ok1 := readString(m, pathPtr, pathLen)
+		name, ok2 := readString(m, namePtr, nameLen)
+		if !ok1 || !ok2 {
+			return router.ERR_FFI_RESERVED
+		}
+		h := mod.wasmHandler(name, extra)
+		if h == nil {
+			return router.ERR_FFI_RESERVED
+		}
+		return router.GetHTTPRouter().Register(mod.capabilities, uint8(mask), router.JoinMount(mod.mount, path), h)
+	}).Export("register_http")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, mask, pathPtr, pathLen uint32) uint64 {
+		path, ok := readString(m, pathPtr, pathLen)
+		if !ok {
+			return router.ERR_FFI_RESERVED
+		}
+		return router.GetHTTPRouter().Unregister(mod.capabilities, uint8(mask), router.JoinMount(mod.mount, path))
+	}).Export("unregister_http")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, mask, pathPtr, pathLen, predsPtr, predCount, namePtr, nameLen, extra uint32) uint64 {
+		path, ok1 := readString(m, pathPtr, pathLen)
+		name, ok2 := readString(m, namePtr, nameLen)
+		preds, ok3 := readPredicates(m, predsPtr, predCount)
+		if !ok1 || !ok2 || !ok3 {
+			return router.ERR_FFI_RESERVED
+		}
+		h := mod.wasmHandler(name, extra)
+		if h == nil {
+			return router.ERR_FFI_RESERVED
+		}
+		return router.GetHTTPRouter().RegisterGuarded(mod.capabilities, uint8(mask), router.JoinMount(mod.mount, path), preds, h)
+	}).Export("register_http_ex")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, mask, pathPtr, pathLen, predsPtr, predCount uint32) uint64 {
+		path, ok1 := readString(m, pathPtr, pathLen)
+		preds, ok2 := readPredicates(m, predsPtr, predCount)
+		if !ok1 || !ok2 {
+			return router.ERR_FFI_RESERVED
+		}
+		return router.GetHTTPRouter().UnregisterGuarded(mod.capabilities, uint8(mask), router.JoinMount(mod.mount, path), preds)
+	}).Export("unregister_http_ex")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, status, ptr, n uint32) uint64 {
+		detail, ok := readString(m, ptr, n)
+		if !ok {
+			return router.ERR_FFI_RESERVED
+		}
+		return mod.reportHealth(status, detail)
+	}).Export("report_health")
+
+	/* Label names and values are NUL separated lists, buckets an array of f64 */
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, kind, namePtr, nameLen, helpPtr, helpLen, labelsPtr, labelsLen, bucketsPtr, bucketCount uint32) uint64 {
+		name, ok1 := readString(m, namePtr, nameLen)
+		help, ok2 := readString(m, helpPtr, helpLen)
+		labels, ok3 := readList(m, labelsPtr, labelsLen)
+		buckets, ok4 := readFloats(m, bucketsPtr, bucketCount)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return 0
+		}
+		return mod.newMetric(kind, name, help, labels, buckets)
+	}).Export("metric_new")
+
+	for name, op := range map[string]uint8{
+		"metric_add":     METRIC_ADD,
+		"metric_set":     METRIC_SET,
+		"metric_observe": METRIC_OBSERVE,
+	} {
+		b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, metric uint64, value float64, valuesPtr, valuesLen uint32) uint64 {
+			values, ok := readList(m, valuesPtr, valuesLen)
+			if !ok {
+				return router.ERR_FFI_RESERVED
+			}
+			return mod.updateMetric(op, metric, value, values)
+		}).Export(name)
+	}
+
+	/* Request accessors copy into the guest buffer only if it is large enough, and always return the full length */
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		if call := callFrom(ctx); call != nil {
+			return writeOut(m, buf, size, []byte(call.path))
+		}
+		return wasmNone
+	}).Export("req_path")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		if call := callFrom(ctx); call != nil {
+			return writeOut(m, buf, size, []byte(call.mount))
+		}
+		return wasmNone
+	}).Export("req_mount_path")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		if call := callFrom(ctx); call != nil {
+			return writeOut(m, buf, size, call.ctx.Method())
+		}
+		return wasmNone
+	}).Export("req_method")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		if call := callFrom(ctx); call != nil {
+			return writeOut(m, buf, size, call.ctx.URI().QueryString())
+		}
+		return wasmNone
+	}).Export("req_query")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, namePtr, nameLen, buf, size uint32) uint32 {
+		call := callFrom(ctx)
+		name, ok := readString(m, namePtr, nameLen)
+		if call == nil || !ok {
+			return wasmNone
+		}
+		v, found := router.PeekHeader(&call.ctx.Request.Header, name)
+		if !found {
+			return wasmNone
+		}
+		return writeOut(m, buf, size, v)
+	}).Export("req_header")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		if call := callFrom(ctx); call != nil {
+			return writeOut(m, buf, size, call.ctx.Request.Body())
+		}
+		return wasmNone
+	}).Export("req_body")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		if call := callFrom(ctx); call != nil {
+			return writeOut(m, buf, size, []byte(router.RequestID(call.ctx)))
+		}
+		return wasmNone
+	}).Export("req_request_id")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, buf, size uint32) uint32 {
+		if call := callFrom(ctx); call != nil {
+			return writeOut(m, buf, size, []byte(router.Traceparent(call.ctx)))
+		}
+		return wasmNone
+	}).Export("req_traceparent")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, status uint32) {
+		if call := callFrom(ctx); call != nil {
+			if status < 100 || status > 999 {
+				call.statusErr = fmt.Errorf("invalid status %d", status)
+				return
+			}
+			call.ctx.SetStatusCode(int(status))
+		}
+	}).Export("resp_status")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, namePtr, nameLen, valuePtr, valueLen uint32) {
+		call := callFrom(ctx)
+		name, ok1 := readString(m, namePtr, nameLen)
+		value, ok2 := readString(m, valuePtr, valueLen)
+		if call != nil && ok1 && ok2 {
+			call.ctx.Response.Header.Set(name, value)
+		}
+	}).Export("resp_header")
+
+	b.NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr, n uint32) {
+		call := callFrom(ctx)
+		data, ok := m.Memory().Read(ptr, n)
+		if call != nil && ok {
+			call.ctx.Write(data)
+		}
+	}).Export("resp_write")
+
+	return b
+}
+
+func readString(m api.Module, ptr, n uint32) (string, bool) {
+	b, ok := m.Memory().Read(ptr, n)
+	if !ok {
+		return "", false
+	}
+	return string(b), true
+}
+
+func readList(m api.Module, ptr, n uint32) ([]string, bool) {
+	if n == 0 {
+		return nil, true
+	}
+	s, ok := readString(m, ptr, n)
+	if !ok {
+		return nil, false
+	}
+	return strings.Split(strings.TrimSuffix(s, "\x00"), "\x00"), true
+}
+
+func readFloats(m api.Module, ptr, count uint32) ([]float64, bool) {
+	raw, ok := readArray(m, ptr, count, 8)
+	if !ok {
+		return nil, false
+	}
+	out := make([]float64, count)
+	for i := range out {
+		out[i] = math.Float64frombits(binary.LittleEndian.Uint64(raw[i*8:]))
+	}
+	return out, true
+}
+
+/*
+ * count elements of size bytes at ptr, checked against guest memory as a
+ * whole before anything is allocated for them.
+ */
+func readArray(m api.Module, ptr, count, size uint32) ([]byte, bool) {
+	n := uint64(count) * uint64(size)
+	if n > uint64(m.Memory().Size()) {
+		return nil, false
+	}
+	return m.Memory().Read(ptr, uint32(n))
+}
+
+func writeOut(m api.Module, buf, size uint32, data []byte) uint32 {
+	if uint32(len(data)) <= size {
+		m.Memory().Write(buf, data)
+	}
+	return uint32(len(data))
+}
+
+/* Layout of or_wasm_predicate_t: kind, name ptr/len, value ptr/len, all u32 */
+const wasmPredicateSize = 5 * 4
+
+func readPredicates(m api.Module, ptr, count uint32) ([]router.Predicate, bool) {
+	if count == 0 {
+		return nil, true
+	}
+
+	raw, ok := readArray(m, ptr, count, wasmPredicateSize)
+	if !ok {
+		return nil, false
+	}
+
+	out := make([]router.Predicate, 0, count)
+	for i := uint32(0); i < count; i++ {
+		field := func(n uint32) uint32 {
+			return binary.LittleEndian.Uint32(raw[uint64(i)*wasmPredicateSize+uint64(n)*4:])
+		}
+		name, ok1 := readString(m, field(1), field(2))
+		value, ok2 := readString(m, field(3), field(4))
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		out = append(out, router.Predicate{Kind: router.PredicateKind(field(0)), Name: name, Value: value})
+	}
+	return out, true
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/router"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type breakerState int
+
+const (
+	STATE_CLOSED    breakerState = 0
+	STATE_OPEN      breakerState = 1
+	STATE_HALF_OPEN breakerState = 2
+)
+
+func (st breakerState) String() string {
+	switch st {
+	case STATE_OPEN:
+		return "open"
+	case STATE_HALF_OPEN:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+/*
+ * Per server circuit breaker:
+ *
+ *   closed    -> open       after maxFails consecutive failures
+ *   open      -> half_open  on the first request after cooldown (the trial)
+ *   half_open -> closed     when the trial (or a probe) succeeds
+ *   half_open -> open       when the trial fails, cooldown starts over
+ *
+ * A successful active probe closes the breaker from any state.
+ */
+type breaker struct {
+	mu       sync.Mutex
+	addr     string
+	upstream string
+	state    breakerState
+	fails    int
+	openedAt time.Time
+	lastErr  string
+	maxFails int
+	cooldown time.Duration
+}
+
+/* Candidate check for balancers, does not take the half-open trial slot */
+func (b *breaker) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case STATE_CLOSED:
+		return true
+	case STATE_OPEN:
+		return time.Since(b.openedAt) >= b.cooldown
+	default:
+		return false
+	}
+}
+
+/* Takes the half-open trial slot if needed, false if the server can't be used */
+func (b *breaker) acquire() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case STATE_CLOSED:
+		return true
+	case STATE_OPEN:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(STATE_HALF_OPEN)
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails = 0
+	b.lastErr = ""
+	if b.state != STATE_CLOSED {
+		b.setState(STATE_CLOSED)
+	}
+}
+
+func (b *breaker) failure(err string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails++
+	b.lastErr = err
+	switch {
+	case b.state == STATE_HALF_OPEN:
+		b.openedAt = time.Now()
+		b.setState(STATE_OPEN)
+	case b.state == STATE_CLOSED && b.fails >= b.maxFails:
+		b.openedAt = time.Now()
+		b.setState(STATE_OPEN)
+	}
+}
+
+/* Caller holds b.mu */
+func (b *breaker) setState(st breakerState) {
+	prev := b.state
+	b.state = st
+	if st == STATE_OPEN {
+		logger.Warn("Upstream server ejected", "upstream", b.upstream, "server", b.addr,
+			"from", prev.String(), "fails", b.fails, "last_err", b.lastErr)
+		return
+	}
+	logger.Info("Upstream server state changed", "upstream", b.upstream, "server", b.addr,
+		"from", prev.String(), "to", st.String())
+}
+
+func runHealthChecks(ctx context.Context, p *Pool, hc config.HealthCheck) {
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+	for {
+		for _, s := range p.servers {
+			probe(p, s, hc)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func probe(p *Pool, s *server, hc config.HealthCheck) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(hc.Path)
+	req.Header.SetHost(s.addr)
+	req.Header.SetMethod(fasthttp.MethodGet)
+	/* The body is not read, so the connection must not go back to the pool live traffic uses */
+	req.SetConnectionClose()
+	resp.SkipBody = true
+
+	err := s.client.DoTimeout(req, resp, hc.Timeout)
+	switch {
+	case err != nil:
+		logger.Debug("Health probe failed", "upstream", p.name, "server", s.addr, "err", err.Error())
+		s.breaker.failure(err.Error())
+	case resp.StatusCode() != hc.Status:
+		logger.Debug("Health probe returned unexpected status", "upstream", p.name, "server", s.addr,
+			"status", resp.StatusCode(), "expected", hc.Status)
+		s.breaker.failure("unexpected status " + fasthttp.StatusMessage(resp.StatusCode()))
+	default:
+		s.breaker.success()
+	}
+}
+
+type ServerStatus struct {
+	Addr    string `json:"addr"`
+	State   string `json:"state"`
+	Fails   int    `json:"fails"`
+	Active  int64  `json:"active"`
+	LastErr string `json:"last_err,omitempty"`
+}
+
+type PoolStatus struct {
+	Upstream string         `json:"upstream"`
+	Servers  []ServerStatus `json:"servers"`
+}
+
+func Status() []PoolStatus {
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
+
+	out := make([]PoolStatus, 0, len(pools))
+	for name, p := range pools {
+		ps := PoolStatus{Upstream: name}
+		for _, s := range p.servers {
+			s.breaker.mu.Lock()
+			ps.Servers = append(ps.Servers, ServerStatus{
+				Addr:    s.addr,
+				State:   s.breaker.state.String(),
+				Fails:   s.breaker.fails,
+				Active:  s.active.Load(),
+				LastErr: s.breaker.lastErr,
+			})
+			s.breaker.mu.Unlock()
+		}
+		out = append(out, ps)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Upstream < out[j].Upstream })
+	return out
+}
+
+type statusHandler struct{}
+
+func (statusHandler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	StatusHandler((*fasthttp.RequestCtx)(cptr))
+}
+
+/* Serves Status() as JSON */
+func StatusHandler(ctx *fasthttp.RequestCtx) {
+	body, err := json.Marshal(Status())
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
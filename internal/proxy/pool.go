@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"net"
+	"omnirouter/internal/config"
+	"omnirouter/internal/router"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const hashReplicas = 128
+
+type server struct {
+	addr    string
+	client  *fasthttp.HostClient
+	active  atomic.Int64
+	breaker *breaker
+}
+
+type balancer interface {
+	/* `skip` rejects servers already tried or ejected */
+	pick(ctx *fasthttp.RequestCtx, skip func(*server) bool) *server
+}
+
+type Pool struct {
+	name    string
+	servers []*server
+	bal     balancer
+	timeout time.Duration
+	retries int
+}
+
+func newPool(name string, up config.Upstream) *Pool {
+	p := &Pool{
+		name:    name,
+		timeout: up.Timeout,
+		retries: up.Retries,
+	}
+
+	for _, addr := range up.Servers {
+		p.servers = append(p.servers, &server{
+			addr: addr,
+			breaker: &breaker{
+				addr:     addr,
+				upstream: name,
+				maxFails: up.MaxFails,
+				cooldown: up.Cooldown,
+			},
+			client: &fasthttp.HostClient{
+				Addr:                          addr,
+				MaxConns:                      up.MaxConns,
+				MaxIdleConnDuration:           30 * time.Second,
+				MaxIdemponentCallAttempts:     1,
+				NoDefaultUserAgentHeader:      true,
+				DisableHeaderNamesNormalizing: true,
+				DisablePathNormalizing:        true,
+			},
+		})
+	}
+
+	switch up.Balance {
+	case "least_conn":
+		p.bal = &leastConn{servers: p.servers}
+	case "consistent_hash":
+		p.bal = newHashRing(p.servers, up.HashKey)
+	default:
+		p.bal = &roundRobin{servers: p.servers}
+	}
+	return p
+}
+
+type roundRobin struct {
+	servers []*server
+	next    atomic.Uint64
+}
+
+func (b *roundRobin) pick(_ *fasthttp.RequestCtx, skip func(*server) bool) *server {
+	n := uint64(len(b.servers))
+	start := b.next.Add(1) - 1
+	for i := range n {
+		s := b.servers[(start+i)%n]
+		if !skip(s) {
+			return s
+		}
+	}
+	return nil
+}
+
+type leastConn struct {
+	servers []*server
+}
+
+func (b *leastConn) pick(_ *fasthttp.RequestCtx, skip func(*server) bool) *server {
+	var best *server
+	for _, s := range b.servers {
+		if skip(s) {
+			continue
+		}
+		if best == nil || s.active.Load() < best.active.Load() {
+			best = s
+		}
+	}
+	return best
+}
+
+type hashRing struct {
+	key    string
+	hashes []uint64
+	owners []*server
+}
+
+func newHashRing(servers []*server, key string) *hashRing {
+	type point struct {
+		h uint64
+		s *server
+	}
+
+	points := make([]point, 0, len(servers)*hashReplicas)
+	for _, s := range servers {
+		for i := range hashReplicas {
+			points = append(points, point{hashString(s.addr + "#" + strconv.Itoa(i)), s})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].h < points[j].h })
+
+	r := &hashRing{key: key}
+	for _, pt := range points {
+		r.hashes = append(r.hashes, pt.h)
+		r.owners = append(r.owners, pt.s)
+	}
+	return r
+}
+
+/* Walks clockwise from the key's point, so a skipped server moves to its successor */
+func (r *hashRing) pick(ctx *fasthttp.RequestCtx, skip func(*server) bool) *server {
+	h := hashString(r.keyOf(ctx))
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	for i := range r.owners {
+		s := r.owners[(start+i)%len(r.owners)]
+		if !skip(s) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (r *hashRing) keyOf(ctx *fasthttp.RequestCtx) string {
+	switch {
+	case r.key == "path":
+		return router.RequestPath(ctx)
+	case strings.HasPrefix(r.key, "header:"):
+		v, _ := router.PeekHeader(&ctx.Request.Header, strings.TrimPrefix(r.key, "header:"))
+		return string(v)
+	case strings.HasPrefix(r.key, "query:"):
+		return string(ctx.QueryArgs().Peek(strings.TrimPrefix(r.key, "query:")))
+	default:
+		return clientIP(ctx)
+	}
+}
+
+/*
+ * FNV-1a keeps similar short strings (user1, user2, host#1, host#2) close
+ * together, the splitmix64 finalizer spreads them over the whole ring.
+ */
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func clientIP(ctx *fasthttp.RequestCtx) string {
+	if ip := ctx.RemoteIP(); ip != nil && !ip.Equal(net.IPv4zero) {
+		return ip.String()
+	}
+	return ""
+}
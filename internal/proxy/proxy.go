@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/router"
+	"strconv"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	poolsMu sync.RWMutex
+	pools   = make(map[string]*Pool)
+)
+
+/* Hop-by-hop headers, never forwarded in either direction */
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type handler struct {
+	pool        *Pool
+	prefix      string
+	stripPrefix bool
+}
+
+var _ router.HTTPHandler = (*handler)(nil)
+
+/*
+ * Builds the upstream pools, starts their health checks and registers every
+ * `proxy` kind route (and the upstream status route if configured).
+ */
+func Setup(ctx context.Context, conf *config.Config) {
+	poolsMu.Lock()
+	for name, up := range conf.Upstreams {
+		p := newPool(name, up)
+		pools[name] = p
+		logger.Info("Upstream pool created", "upstream", name, "servers", up.Servers, "balance", up.Balance)
+		if up.HealthCheck.Path != "" {
+			go runHealthChecks(ctx, p, up.HealthCheck)
+		}
+	}
+	poolsMu.Unlock()
+
+	if conf.Router.UpstreamStatus != "" {
+		router.GetHTTPRouter().Register(capabilities.Unrestricted(), router.METHOD_GET, conf.Router.UpstreamStatus, statusHandler{})
+	}
+
+	for _, r := range conf.Routes {
+		if r.Kind != config.ROUTE_PROXY {
+			continue
+		}
+
+		mask, err := router.ParseMethods(r.Methods)
+		if err != nil {
+			logger.Error("Invalid methods in route config", "path", r.Path, "upstream", r.Upstream, "err", err)
+			continue
+		}
+
+		h := &handler{pool: GetPool(r.Upstream), prefix: r.Path, stripPrefix: r.StripPrefix}
+		router.GetHTTPRouter().Register(capabilities.Unrestricted(), mask, r.Pattern(), h)
+	}
+}
+
+func GetPool(name string) *Pool {
+	poolsMu.RLock()
+	defer poolsMu.RUnlock()
+	return pools[name]
+}
+
+func (h *handler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+	path := router.RequestPath(ctx)
+	if h.stripPrefix {
+		path = router.StripMount(h.prefix, path)
+	}
+	h.pool.forward(ctx, path)
+}
+
+func (p *Pool) forward(ctx *fasthttp.RequestCtx, path string) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	ctx.Request.CopyTo(req)
+	uri := router.EscapePath(path)
+	if q := ctx.URI().QueryString(); len(q) > 0 {
+		uri += "?" + string(q)
+	}
+	req.SetRequestURI(uri)
+	removeHopHeaders(&req.Header)
+	setForwardedHeaders(ctx, req)
+
+	/* Only idempotent requests are retried, on another server if there is one */
+	attempts := 1
+	if isIdempotent(ctx.Method()) {
+		attempts += p.retries
+	}
+
+	var err error
+	tried := make(map[*server]bool, attempts)
+	skip := func(s *server) bool { return tried[s] || !s.breaker.ready() }
+	for attempt := 0; attempt < attempts; {
+		s := p.bal.pick(ctx, skip)
+		if s == nil {
+			if len(tried) == 0 {
+				break
+			}
+			/* Fewer usable servers than attempts, start over */
+			clear(tried)
+			if s = p.bal.pick(ctx, skip); s == nil {
+				break
+			}
+		}
+		tried[s] = true
+
+		/* Lost the half-open trial to another request, does not count as an attempt */
+		if !s.breaker.acquire() {
+			continue
+		}
+		attempt++
+
+		s.active.Add(1)
+		err = s.client.DoTimeout(req, &ctx.Response, p.timeout)
+		s.active.Add(-1)
+		if err == nil {
+			/* The answer is passed on either way, but a failing server is ejected like an unreachable one */
+			if code := ctx.Response.StatusCode(); code >= fasthttp.StatusInternalServerError {
+				s.breaker.failure("upstream status " + strconv.Itoa(code))
+			} else {
+				s.breaker.success()
+			}
+			removeHopHeaders(&ctx.Response.Header)
+			return
+		}
+
+		logger.WarnContext(ctx, "Upstream request failed", "upstream", p.name, "server", s.addr, "err", err)
+		s.breaker.failure(err.Error())
+		ctx.Response.Reset()
+	}
+
+	if err == nil {
+		logger.ErrorContext(ctx, "No upstream server available", "upstream", p.name)
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, fasthttp.ErrTimeout) {
+		ctx.SetStatusCode(fasthttp.StatusGatewayTimeout)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusBadGateway)
+}
+
+func isIdempotent(method []byte) bool {
+	switch string(method) {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions,
+		fasthttp.MethodPut, fasthttp.MethodDelete:
+		return true
+	}
+	return false
+}
+
+type header interface {
+	All() iter.Seq2[[]byte, []byte]
+	Del(key string)
+}
+
+/* Header names are kept as received, so match them case-insensitively */
+func removeHeaderFold(h header, names ...string) {
+	var keys []string
+	for k := range h.All() {
+		for _, n := range names {
+			if bytes.EqualFold(k, []byte(n)) {
+				keys = append(keys, string(k))
+			}
+		}
+	}
+	for _, k := range keys {
+		h.Del(k)
+	}
+}
+
+func removeHopHeaders(h header) {
+	/* Headers named in Connection are hop-by-hop as well */
+	var extra []string
+	for k, v := range h.All() {
+		if bytes.EqualFold(k, []byte("Connection")) {
+			for _, n := range bytes.Split(v, []byte{','}) {
+				if n = bytes.TrimSpace(n); len(n) > 0 {
+					extra = append(extra, string(n))
+				}
+			}
+		}
+	}
+	removeHeaderFold(h, append(extra, hopHeaders...)...)
+}
+
+func setForwardedHeaders(ctx *fasthttp.RequestCtx, req *fasthttp.Request) {
+	xff := clientIP(ctx)
+	if prior, ok := router.PeekHeader(&req.Header, "X-Forwarded-For"); ok && len(prior) > 0 {
+		xff = string(prior) + ", " + xff
+	}
+
+	proto := "http"
+	if ctx.IsTLS() {
+		proto = "https"
+	}
+
+	removeHeaderFold(&req.Header, "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host")
+	req.Header.Set("X-Forwarded-For", xff)
+	req.Header.Set("X-Forwarded-Proto", proto)
+	req.Header.Set("X-Forwarded-Host", string(ctx.Host()))
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"net"
+	"omnirouter/internal/config"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+/* A local stand-in for an upstream server, counting the requests it answers */
+type upstream struct {
+	ln   *fasthttputil.InmemoryListener
+	hits atomic.Int64
+}
+
+func startUpstream(t *testing.T, h fasthttp.RequestHandler) *upstream {
+	t.Helper()
+	u := &upstream{ln: fasthttputil.NewInmemoryListener()}
+	srv := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		u.hits.Add(1)
+		h(ctx)
+	}}
+	go srv.Serve(u.ln)
+	t.Cleanup(func() { u.ln.Close() })
+	return u
+}
+
+/* Accepts connections and drops them right away, like a crashed server */
+func startBrokenUpstream(t *testing.T) *upstream {
+	t.Helper()
+	u := &upstream{ln: fasthttputil.NewInmemoryListener()}
+	go func() {
+		for {
+			c, err := u.ln.Accept()
+			if err != nil {
+				return
+			}
+			u.hits.Add(1)
+			c.Close()
+		}
+	}()
+	t.Cleanup(func() { u.ln.Close() })
+	return u
+}
+
+func ok(ctx *fasthttp.RequestCtx) { ctx.SetBodyString("ok") }
+
+func testPool(up config.Upstream, upstreams ...*upstream) *Pool {
+	for i := range upstreams {
+		up.Servers = append(up.Servers, "up"+strconv.Itoa(i))
+	}
+	if up.Timeout == 0 {
+		up.Timeout = 2 * time.Second
+	}
+	if up.MaxFails == 0 {
+		up.MaxFails = 100
+	}
+	p := newPool("test", up)
+	for i, s := range p.servers {
+		ln := upstreams[i].ln
+		s.client.Dial = func(string) (net.Conn, error) { return ln.Dial() }
+	}
+	return p
+}
+
+/* Proxies one request the way the route handler does, returning the response */
+func do(p *Pool, method, path string, setup func(*fasthttp.Request)) *fasthttp.Response {
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(path)
+	req.Header.SetHost("example.com")
+	if setup != nil {
+		setup(&req)
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4321}, nil)
+	p.forward(&ctx, string(ctx.Path()))
+
+	resp := &fasthttp.Response{}
+	ctx.Response.CopyTo(resp)
+	return resp
+}
+
+func TestRoundRobin(t *testing.T) {
+	ups := []*upstream{startUpstream(t, ok), startUpstream(t, ok), startUpstream(t, ok)}
+	p := testPool(config.Upstream{Balance: "round_robin"}, ups...)
+
+	for range 9 {
+		if resp := do(p, fasthttp.MethodGet, "/", nil); resp.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode())
+		}
+	}
+	for i, u := range ups {
+		if n := u.hits.Load(); n != 3 {
+			t.Errorf("upstream %d got %d requests, want 3", i, n)
+		}
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	release := make(chan struct{})
+	slow := startUpstream(t, func(ctx *fasthttp.RequestCtx) {
+		<-release
+		ok(ctx)
+	})
+	fast := startUpstream(t, ok)
+	p := testPool(config.Upstream{Balance: "least_conn"}, slow, fast)
+
+	/* Ties go to the first server, which then stays busy */
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		do(p, fasthttp.MethodGet, "/", nil)
+	}()
+	for p.servers[0].active.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for range 5 {
+		do(p, fasthttp.MethodGet, "/", nil)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := slow.hits.Load(); n != 1 {
+		t.Errorf("busy upstream got %d requests, want 1", n)
+	}
+	if n := fast.hits.Load(); n != 5 {
+		t.Errorf("idle upstream got %d requests, want 5", n)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	ups := []*upstream{startUpstream(t, ok), startUpstream(t, ok), startUpstream(t, ok)}
+	p := testPool(config.Upstream{Balance: "consistent_hash", HashKey: "header:X-User"}, ups...)
+
+	owner := func(user string) int {
+		before := make([]int64, len(ups))
+		for i, u := range ups {
+			before[i] = u.hits.Load()
+		}
+		do(p, fasthttp.MethodGet, "/", func(r *fasthttp.Request) { r.Header.Set("X-User", user) })
+		for i, u := range ups {
+			if u.hits.Load() != before[i] {
+				return i
+			}
+		}
+		t.Fatalf("request for %q reached no upstream", user)
+		return -1
+	}
+
+	used := map[int]bool{}
+	for i := range 30 {
+		user := "user" + strconv.Itoa(i)
+		first := owner(user)
+		for range 3 {
+			if again := owner(user); again != first {
+				t.Fatalf("%q moved from upstream %d to %d", user, first, again)
+			}
+		}
+		used[first] = true
+	}
+	if len(used) != len(ups) {
+		t.Errorf("30 keys only spread over %d of %d upstreams", len(used), len(ups))
+	}
+}
+
+func TestRetriesOnlyIdempotent(t *testing.T) {
+	for _, tc := range []struct {
+		method  string
+		status  int
+		healthy int64
+	}{
+		{fasthttp.MethodGet, fasthttp.StatusOK, 1},
+		{fasthttp.MethodPut, fasthttp.StatusOK, 1},
+		{fasthttp.MethodPost, fasthttp.StatusBadGateway, 0},
+		{fasthttp.MethodPatch, fasthttp.StatusBadGateway, 0},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			broken, healthy := startBrokenUpstream(t), startUpstream(t, ok)
+			p := testPool(config.Upstream{Balance: "round_robin", Retries: 2}, broken, healthy)
+
+			resp := do(p, tc.method, "/", nil)
+			if resp.StatusCode() != tc.status {
+				t.Errorf("status %d, want %d", resp.StatusCode(), tc.status)
+			}
+			if n := broken.hits.Load(); n != 1 {
+				t.Errorf("broken upstream tried %d times, want 1", n)
+			}
+			if n := healthy.hits.Load(); n != tc.healthy {
+				t.Errorf("healthy upstream got %d requests, want %d", n, tc.healthy)
+			}
+		})
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	got := make(chan *fasthttp.RequestHeader, 1)
+	u := startUpstream(t, func(ctx *fasthttp.RequestCtx) {
+		h := &fasthttp.RequestHeader{}
+		ctx.Request.Header.CopyTo(h)
+		got <- h
+		ok(ctx)
+	})
+	p := testPool(config.Upstream{}, u)
+
+	do(p, fasthttp.MethodGet, "/", func(r *fasthttp.Request) {
+		r.Header.Set("X-Forwarded-For", "192.0.2.7")
+		r.Header.Set("x-forwarded-proto", "https")
+		r.Header.Set("X-Forwarded-Host", "spoofed.example")
+		r.Header.Set("Connection", "X-Secret")
+		r.Header.Set("X-Secret", "hop")
+		r.Header.Set("Keep-Alive", "timeout=5")
+	})
+	h := <-got
+
+	for name, want := range map[string]string{
+		"X-Forwarded-For":   "192.0.2.7, 10.0.0.1",
+		"X-Forwarded-Proto": "http",
+		"X-Forwarded-Host":  "example.com",
+		"X-Secret":          "",
+		"Keep-Alive":        "",
+	} {
+		if v := string(h.Peek(name)); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package router
 
 import (
+	"fmt"
 	"omnirouter/internal/capabilities"
 	"omnirouter/internal/logger"
+	"strings"
+
+	"github.com/valyala/fasthttp"
 )
 
 const (
@@ -12,6 +16,9 @@ const (
 	ERR_REG_CAP      = 2
 	ERR_REG_WILD_CAP = 3
 	ERR_UNREG_CAP    = 2
+	ERR_INVALID_PRED = 4
+	ERR_SCOPE        = 5
+	ERR_METRIC       = 6
 )
 
 const methodCount = 7
@@ -27,22 +34,81 @@ const (
 	METHOD_ANY     uint8 = ^uint8(0)
 )
 
-func (r *radixRouter) Register(caps capabilities.Capabilities, methodMask uint8, path string, h HTTPHandler) uint64 {
-	if !capabilities.HasCapabilities(caps, capabilities.CAP_HTTP_REGISTER) {
+func MethodBit(method string) uint8 {
+	switch method {
+	case fasthttp.MethodGet:
+		return METHOD_GET
+	case fasthttp.MethodHead:
+		return METHOD_HEAD
+	case fasthttp.MethodPost:
+		return METHOD_POST
+	case fasthttp.MethodPut:
+		return METHOD_PUT
+	case fasthttp.MethodDelete:
+		return METHOD_DELETE
+	case fasthttp.MethodPatch:
+		return METHOD_PATCH
+	case fasthttp.MethodOptions:
+		return METHOD_OPTIONS
+	default:
+		return METHOD_UNKNOWN
+	}
+}
+
+/* Method names from config, an empty list or "ANY" means every method */
+func ParseMethods(methods []string) (uint8, error) {
+	if len(methods) == 0 {
+		return METHOD_ANY, nil
+	}
+
+	var mask uint8
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		if m == "ANY" {
+			return METHOD_ANY, nil
+		}
+		bit := MethodBit(m)
+		if bit == METHOD_UNKNOWN {
+			return METHOD_UNKNOWN, fmt.Errorf("unknown HTTP method %q", m)
+		}
+		mask |= bit
+	}
+	return mask, nil
+}
+
+func (r *radixRouter) Register(caps capabilities.Set, methodMask uint8, path string, h HTTPHandler) uint64 {
+	return r.RegisterGuarded(caps, methodMask, path, nil, h)
+}
+
+/* Without predicates this is the same as Register, see predicate.go for the ordering */
+func (r *radixRouter) RegisterGuarded(caps capabilities.Set, methodMask uint8, path string, preds []Predicate, h HTTPHandler) uint64 {
+	if !caps.Has(capabilities.CAP_HTTP_REGISTER) {
 		logger.Warn("Insufficient capabilities to register an HTTP route",
-			"capabilities", caps, "needed", capabilities.CAP_HTTP_REGISTER)
+			"capabilities", caps.String(), "needed", capabilities.CAP_HTTP_REGISTER)
 		return ERR_REG_CAP
 	}
 
 	p, isWildcard := cleanURI(path)
-	if isWildcard && !capabilities.HasCapabilities(caps, capabilities.CAP_HTTP_REGISTER_WILDCARD) {
+	if isWildcard && !caps.Has(capabilities.CAP_HTTP_REGISTER_WILDCARD) {
 		logger.Warn("Insufficient capabilities to register a wildcard HTTP route",
-			"capabilities", caps,
+			"capabilities", caps.String(),
 			"needed", capabilities.CAP_HTTP_REGISTER_WILDCARD&capabilities.CAP_HTTP_REGISTER)
 		return ERR_REG_WILD_CAP
 	}
 
+	if !inScope(caps, capabilities.CAP_HTTP_REGISTER, p, isWildcard) ||
+		(isWildcard && !inScope(caps, capabilities.CAP_HTTP_REGISTER_WILDCARD, p, isWildcard)) {
+		return ERR_SCOPE
+	}
+
+	if !validPredicates(preds) {
+		logger.Warn("Invalid predicate in HTTP route registration", "path", p)
+		return ERR_INVALID_PRED
+	}
+
 	re := r.getOrCreate(p)
+	var change *RouteChange
+	defer func() { notifyRoute(change) }()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -50,23 +116,47 @@ func (r *radixRouter) Register(caps capabilities.Capabilities, methodMask uint8,
 		re.wildcard = true
 	}
 
-	execForMethodBit(func(i int) {
-		re.table.Handlers[i] = h
-	}, uint8(methodMask))
+	if len(preds) == 0 {
+		execForMethodBit(func(i int) {
+			re.table.Handlers[i] = h
+		}, uint8(methodMask))
+	} else {
+		gh := guardedHandler{
+			preds:   append([]Predicate(nil), preds...),
+			key:     predicateKey(preds),
+			handler: h,
+		}
+		execForMethodBit(func(i int) {
+			re.table.guarded[i] = insertGuarded(re.table.guarded[i], gh)
+		}, uint8(methodMask))
+	}
 
-	logger.Info("Added/updated HTTP handler", "path", p, "wildcard", isWildcard, "method_mask", methodMask)
+	logger.Info("Added/updated HTTP handler", "path", p, "wildcard", isWildcard, "method_mask", methodMask, "predicates", len(preds))
+	change = &RouteChange{
+		Action:     ROUTE_REGISTERED,
+		Path:       p,
+		Wildcard:   re.wildcard,
+		Methods:    methodList(methodMask),
+		Owner:      handlerOwner(h),
+		Predicates: predicateStrings(preds),
+	}
 	return SUCCESS
 }
 
-func (r *radixRouter) Unregister(caps capabilities.Capabilities, methodMask uint8, path string) uint64 {
-	if !capabilities.HasCapabilities(caps, capabilities.CAP_HTTP_UNREGISTER) {
+func (r *radixRouter) Unregister(caps capabilities.Set, methodMask uint8, path string) uint64 {
+	if !caps.Has(capabilities.CAP_HTTP_UNREGISTER) {
 		logger.Warn("Insufficient capabilities to unregister an HTTP route",
-			"capabilities", caps, "needed", capabilities.CAP_HTTP_UNREGISTER)
+			"capabilities", caps.String(), "needed", capabilities.CAP_HTTP_UNREGISTER)
 		return ERR_UNREG_CAP
 	}
 
-	p, _ := cleanURI(path)
+	p, isWildcard := cleanURI(path)
+	if !inScope(caps, capabilities.CAP_HTTP_UNREGISTER, p, isWildcard) {
+		return ERR_SCOPE
+	}
 
+	var change *RouteChange
+	defer func() { notifyRoute(change) }()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -76,11 +166,79 @@ func (r *radixRouter) Unregister(caps capabilities.Capabilities, methodMask uint
 		return SUCCESS
 	}
 
+	/* Drops the guarded handlers of these methods too */
 	re := v.(*routeEntry)
+	var owner string
 	execForMethodBit(func(i int) {
+		if h := re.table.Handlers[i]; h != nil && owner == "" {
+			owner = handlerOwner(h)
+		}
+		for _, gh := range re.table.guarded[i] {
+			if owner == "" {
+				owner = handlerOwner(gh.handler)
+			}
+		}
 		re.table.Handlers[i] = nil
+		re.table.guarded[i] = nil
 	}, methodMask)
 
 	logger.Info("Unregistered HTTP handler", "path", p, "method_mask", methodMask)
+	change = &RouteChange{Action: ROUTE_UNREGISTERED, Path: p, Wildcard: re.wildcard, Methods: methodList(methodMask), Owner: owner}
+	return SUCCESS
+}
+
+func (r *radixRouter) UnregisterGuarded(caps capabilities.Set, methodMask uint8, path string, preds []Predicate) uint64 {
+	if !caps.Has(capabilities.CAP_HTTP_UNREGISTER) {
+		logger.Warn("Insufficient capabilities to unregister an HTTP route",
+			"capabilities", caps.String(), "needed", capabilities.CAP_HTTP_UNREGISTER)
+		return ERR_UNREG_CAP
+	}
+
+	p, isWildcard := cleanURI(path)
+	if !inScope(caps, capabilities.CAP_HTTP_UNREGISTER, p, isWildcard) {
+		return ERR_SCOPE
+	}
+
+	var change *RouteChange
+	defer func() { notifyRoute(change) }()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.tree.Get(p)
+	if !ok {
+		logger.Info("Unregister called on missing path", "path", p)
+		return SUCCESS
+	}
+
+	re := v.(*routeEntry)
+	key := predicateKey(preds)
+	var owner string
+	execForMethodBit(func(i int) {
+		if len(preds) == 0 {
+			if h := re.table.Handlers[i]; h != nil && owner == "" {
+				owner = handlerOwner(h)
+			}
+			re.table.Handlers[i] = nil
+			return
+		}
+		for _, gh := range re.table.guarded[i] {
+			if gh.key == key && owner == "" {
+				owner = handlerOwner(gh.handler)
+			}
+		}
+		re.table.guarded[i] = removeGuarded(re.table.guarded[i], key)
+	}, methodMask)
+
+	logger.Info("Unregistered guarded HTTP handler", "path", p, "method_mask", methodMask, "predicates", len(preds))
+	change = &RouteChange{Action: ROUTE_UNREGISTERED, Path: p, Wildcard: re.wildcard, Methods: methodList(methodMask), Owner: owner, Predicates: predicateStrings(preds)}
 	return SUCCESS
 }
+
+func inScope(caps capabilities.Set, c capabilities.Capabilities, p string, isWildcard bool) bool {
+	if caps.AllowsPath(c, p, isWildcard) {
+		return true
+	}
+	logger.Warn("HTTP route is outside of the module's capability scope",
+		"capabilities", caps.String(), "needed", c, "path", p, "wildcard", isWildcard)
+	return false
+}
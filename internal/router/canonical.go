@@ -0,0 +1,247 @@
+package router
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+type EncodedSlashPolicy uint8
+
+const (
+	/* 400 on %2F / %5C, nothing can smuggle a separator past the router */
+	SLASH_REJECT EncodedSlashPolicy = 0
+	/* Decode into a real separator before routing */
+	SLASH_DECODE EncodedSlashPolicy = 1
+	/* Keep the escape as-is, it stays part of a single segment */
+	SLASH_KEEP EncodedSlashPolicy = 2
+)
+
+type DotSegmentPolicy uint8
+
+const (
+	/* Resolve `.` and `..`, climbing above `/` is still rejected */
+	DOTS_RESOLVE DotSegmentPolicy = 0
+	/* 400 on any `.` or `..` segment */
+	DOTS_REJECT DotSegmentPolicy = 1
+)
+
+/*
+ * Canonicalization policy applied in dispatch before Lookup. Whatever the
+ * policy, the result never contains a `..` segment, so a prefix-scoped
+ * module can not be reached with a path outside its mount.
+ */
+type PathPolicy struct {
+	Decode          bool
+	CollapseSlashes bool
+	RejectControl   bool
+	EncodedSlash    EncodedSlashPolicy
+	DotSegments     DotSegmentPolicy
+}
+
+var pathPolicy atomic.Pointer[PathPolicy]
+
+func DefaultPathPolicy() PathPolicy {
+	return PathPolicy{
+		Decode:          true,
+		CollapseSlashes: true,
+		RejectControl:   true,
+		EncodedSlash:    SLASH_REJECT,
+		DotSegments:     DOTS_RESOLVE,
+	}
+}
+
+func SetPathPolicy(p PathPolicy) {
+	pathPolicy.Store(&p)
+}
+
+func getPathPolicy() PathPolicy {
+	if p := pathPolicy.Load(); p != nil {
+		return *p
+	}
+	return DefaultPathPolicy()
+}
+
+func ParseEncodedSlashPolicy(s string) (EncodedSlashPolicy, bool) {
+	switch strings.ToLower(s) {
+	case "", "reject":
+		return SLASH_REJECT, true
+	case "decode":
+		return SLASH_DECODE, true
+	case "keep":
+		return SLASH_KEEP, true
+	default:
+		return SLASH_REJECT, false
+	}
+}
+
+func ParseDotSegmentPolicy(s string) (DotSegmentPolicy, bool) {
+	switch strings.ToLower(s) {
+	case "", "resolve":
+		return DOTS_RESOLVE, true
+	case "reject":
+		return DOTS_REJECT, true
+	default:
+		return DOTS_RESOLVE, false
+	}
+}
+
+/*
+ * Turns the raw request path into the one used for routing. Returns false if
+ * the path has to be rejected: malformed escapes, control bytes (NUL
+ * included), encoded separators or dot segments if the policy says so, and
+ * `..` climbing above the root in any case.
+ */
+func canonicalPath(raw string, policy PathPolicy) (string, bool) {
+	segments := strings.Split(raw, "/")
+	out := make([]string, 0, len(segments))
+
+	for i, seg := range segments {
+		if policy.Decode {
+			var ok bool
+			seg, ok = decodeSegment(seg, policy)
+			if !ok {
+				return "", false
+			}
+		} else if policy.RejectControl && hasControl(seg) {
+			return "", false
+		}
+
+		switch {
+		case seg == "" && i > 0 && i < len(segments)-1 && !policy.CollapseSlashes:
+			out = append(out, seg)
+		case seg == "":
+		case seg == "." || seg == "..":
+			if policy.DotSegments == DOTS_REJECT {
+				return "", false
+			}
+			if seg == ".." {
+				if len(out) == 0 {
+					return "", false
+				}
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+
+	/* An encoded slash decoded into a separator may form new segments */
+	p := "/" + strings.Join(out, "/")
+	if policy.Decode && policy.EncodedSlash == SLASH_DECODE {
+		undecoded := policy
+		undecoded.Decode = false
+		return canonicalPath(p, undecoded)
+	}
+
+	return normalize(p), true
+}
+
+func decodeSegment(seg string, policy PathPolicy) (string, bool) {
+	if strings.IndexByte(seg, '%') < 0 {
+		if policy.RejectControl && hasControl(seg) {
+			return "", false
+		}
+		return seg, true
+	}
+
+	var b strings.Builder
+	b.Grow(len(seg))
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+
+		if i+2 >= len(seg) {
+			return "", false
+		}
+		hi, ok1 := unhex(seg[i+1])
+		lo, ok2 := unhex(seg[i+2])
+		if !ok1 || !ok2 {
+			return "", false
+		}
+		d := hi<<4 | lo
+
+		if d == '/' || d == '\\' {
+			switch policy.EncodedSlash {
+			case SLASH_REJECT:
+				return "", false
+			case SLASH_KEEP:
+				b.WriteString(strings.ToUpper(seg[i : i+3]))
+				i += 2
+				continue
+			case SLASH_DECODE:
+				d = '/'
+			}
+		}
+
+		/* Keep a decoded `%` distinguishable from a kept separator escape */
+		if d == '%' && policy.EncodedSlash == SLASH_KEEP {
+			b.WriteString("%25")
+			i += 2
+			continue
+		}
+
+		b.WriteByte(d)
+		i += 2
+	}
+
+	s := b.String()
+	if policy.RejectControl && hasControl(s) {
+		return "", false
+	}
+	return s, true
+}
+
+func hasControl(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+/*
+ * Escapes a canonical path again for handing it to another HTTP server. With
+ * SLASH_KEEP every `%` in it already starts an escape (%2F, %5C or %25).
+ */
+func EscapePath(p string) string {
+	keep := getPathPolicy().EncodedSlash == SLASH_KEEP
+
+	var b strings.Builder
+	b.Grow(len(p))
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if (c == '%' && keep) || isPathChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte("0123456789ABCDEF"[c>>4])
+		b.WriteByte("0123456789ABCDEF"[c&0xf])
+	}
+	return b.String()
+}
+
+/* RFC 3986 pchar plus `/`, minus the escape introducer */
+func isPathChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-._~!$&'()*+,;=:@/", c) >= 0
+}
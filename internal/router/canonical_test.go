@@ -0,0 +1,148 @@
+package router
+
+import (
+	"omnirouter/internal/capabilities"
+	"strings"
+	"testing"
+)
+
+/* bit 0 Decode, 1 CollapseSlashes, 2 RejectControl, 3-4 EncodedSlash, 5 DotSegments */
+func fuzzPolicy(flags uint8) PathPolicy {
+	return PathPolicy{
+		Decode:          flags&1 != 0,
+		CollapseSlashes: flags&2 != 0,
+		RejectControl:   flags&4 != 0,
+		EncodedSlash:    EncodedSlashPolicy(flags >> 3 & 3 % 3),
+		DotSegments:     DotSegmentPolicy(flags >> 5 & 1),
+	}
+}
+
+var canonicalSeeds = []string{
+	"",
+	"/",
+	"//",
+	"/a/b/c",
+	"/a//b/",
+	"/a/./b/../c",
+	"/..",
+	"/a/../..",
+	"/%2e%2e/etc/passwd",
+	"/a/%2E%2e/%2e%2E/b",
+	"/a%2fb/..",
+	"/a%5c..%5cb",
+	"/a%2F%2e%2e%2F%2e%2e",
+	"/%00",
+	"/a\x00b",
+	"/%zz",
+	"/%2",
+	"/%25%32%66",
+	"/mnt/../other",
+	"/mnt/%2e%2e/other",
+	"/mnt%2f..%2fother",
+	"/mntx/..",
+	"/mnt/./../mnt/x",
+}
+
+func FuzzCanonicalPath(f *testing.F) {
+	for _, s := range canonicalSeeds {
+		f.Add(s, uint8(0x07))
+		f.Add(s, uint8(0x0f))
+		f.Add(s, uint8(0x14))
+		f.Add(s, uint8(0x25))
+	}
+
+	f.Fuzz(func(t *testing.T, raw string, flags uint8) {
+		policy := fuzzPolicy(flags)
+		out, ok := canonicalPath(raw, policy)
+		if !ok {
+			return
+		}
+
+		if !strings.HasPrefix(out, "/") {
+			t.Fatalf("%q -> %q: not absolute", raw, out)
+		}
+		for _, seg := range strings.Split(out, "/") {
+			if seg == "." || seg == ".." {
+				t.Fatalf("%q -> %q: dot segment left", raw, out)
+			}
+		}
+		if policy.CollapseSlashes && strings.Contains(out, "//") {
+			t.Fatalf("%q -> %q: empty segment left", raw, out)
+		}
+		if policy.RejectControl && hasControl(out) {
+			t.Fatalf("%q -> %q: control byte left", raw, out)
+		}
+
+		/* Decoding again would decode a decoded `%`, everything else is a fixpoint */
+		undecoded := policy
+		undecoded.Decode = false
+		again, ok := canonicalPath(out, undecoded)
+		if !ok || again != out {
+			t.Fatalf("%q -> %q -> %q (%v): not idempotent", raw, out, again, ok)
+		}
+		if strings.IndexByte(out, '%') < 0 || policy.EncodedSlash == SLASH_KEEP {
+			again, ok = canonicalPath(out, policy)
+			if !ok || again != out {
+				t.Fatalf("%q -> %q -> %q (%v): not idempotent with decoding", raw, out, again, ok)
+			}
+		}
+	})
+}
+
+type fuzzHandler string
+
+func (fuzzHandler) Invoke(ContextPtr, RequestPtr) {}
+
+func FuzzLookup(f *testing.F) {
+	r := NewHTTPRouter()
+	caps := capabilities.Unrestricted()
+	for _, p := range []string{"/mnt/*", "/mnt/exact", "/other/*", "/mntx", "/*"} {
+		r.Register(caps, METHOD_GET, p, fuzzHandler(p))
+	}
+	mounts := []string{"/mnt", "/other"}
+
+	for _, s := range canonicalSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		if p, _ := cleanURI(raw); !strings.HasPrefix(p, "/") || p != "/" && strings.HasSuffix(p, "/") {
+			t.Fatalf("cleanURI(%q) = %q", raw, p)
+		}
+
+		path, ok := canonicalPath(raw, DefaultPathPolicy())
+		if !ok {
+			return
+		}
+		table, ok := r.Lookup(path)
+		if !ok {
+			t.Fatalf("%q -> %q: no route, / should catch everything", raw, path)
+		}
+		var h fuzzHandler
+		for _, hh := range table.Handlers {
+			if hh != nil {
+				h = hh.(fuzzHandler)
+			}
+		}
+		pattern := table.Pattern()
+		if string(h) != pattern {
+			t.Fatalf("%q -> %q: pattern %q does not belong to handler %q", raw, path, pattern, h)
+		}
+		if !strings.HasSuffix(pattern, "/*") && pattern != path {
+			t.Fatalf("%q -> %q: exact route %q matched another path", raw, path, pattern)
+		}
+
+		for _, m := range mounts {
+			inside := path == m || strings.HasPrefix(path, m+"/")
+			if pattern == m+"/*" && !inside {
+				t.Fatalf("%q -> %q: matched %s outside its mount", raw, path, pattern)
+			}
+			if inside {
+				rel := StripMount(m, path)
+				if !strings.HasPrefix(rel, "/") || strings.Contains("/"+rel+"/", "/../") {
+					t.Fatalf("%q -> %q: %q relative to %s escapes it", raw, path, rel, m)
+				}
+			}
+		}
+	})
+}
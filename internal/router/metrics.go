@@ -0,0 +1,22 @@
+package router
+
+import (
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/metrics"
+
+	"github.com/valyala/fasthttp"
+)
+
+type metricsHandler struct{}
+
+func (metricsHandler) Invoke(cptr ContextPtr, _ RequestPtr) {
+	metrics.Serve((*fasthttp.RequestCtx)(cptr))
+}
+
+/* Serves the Prometheus metrics on the main listener, an empty path disables it */
+func ServeMetrics(path string) {
+	if path == "" {
+		return
+	}
+	GetHTTPRouter().Register(capabilities.Unrestricted(), METHOD_GET|METHOD_HEAD, path, metricsHandler{})
+}
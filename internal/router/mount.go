@@ -0,0 +1,38 @@
+package router
+
+import "strings"
+
+/*
+ * Prefixes a module-relative route path with the module's mount, keeping a
+ * trailing `/*`. An empty mount leaves the path untouched.
+ */
+func JoinMount(mount string, path string) string {
+	if mount == "" || mount == "/" {
+		return path
+	}
+
+	p, isWildcard := cleanURI(path)
+	if p == "/" {
+		p = ""
+	}
+
+	joined := normalize(mount + p)
+	if isWildcard {
+		joined += "/*"
+	}
+	return joined
+}
+
+/* Inverse of JoinMount for a request path, paths outside the mount are kept as-is */
+func StripMount(mount string, path string) string {
+	if mount == "" || mount == "/" {
+		return path
+	}
+	if path == mount {
+		return "/"
+	}
+	if strings.HasPrefix(path, mount+"/") {
+		return path[len(mount):]
+	}
+	return path
+}
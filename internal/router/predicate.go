@@ -0,0 +1,207 @@
+package router
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+type PredicateKind uint8
+
+const (
+	PRED_UNKNOWN      PredicateKind = 0
+	PRED_HEADER       PredicateKind = 1
+	PRED_QUERY        PredicateKind = 2
+	PRED_ACCEPT       PredicateKind = 3
+	PRED_CONTENT_TYPE PredicateKind = 4
+)
+
+/*
+ * A predicate narrows down a route registration beyond path and method.
+ *
+ *   PRED_HEADER:       header `Name` is present (empty Value) or equals Value
+ *   PRED_QUERY:        query arg `Name` is present (empty Value) or equals Value
+ *   PRED_ACCEPT:       the Accept header lists media type Value (or `type/*`)
+ *   PRED_CONTENT_TYPE: the request Content-Type media type equals Value
+ *
+ * Header names and media types are compared case-insensitively, values are not.
+ */
+type Predicate struct {
+	Kind  PredicateKind
+	Name  string
+	Value string
+}
+
+/*
+ * Guarded handlers are evaluated in dispatch after Lookup, in this order:
+ *
+ *   1. guarded handlers with more predicates before ones with fewer
+ *   2. on a tie, the one registered first wins
+ *   3. the first handler whose predicates ALL match is invoked
+ *   4. if none match, the unguarded handler (plain Register) is the fallback
+ */
+type guardedHandler struct {
+	preds   []Predicate
+	key     string
+	handler HTTPHandler
+}
+
+func validPredicates(preds []Predicate) bool {
+	for _, p := range preds {
+		switch p.Kind {
+		case PRED_HEADER, PRED_QUERY:
+			if p.Name == "" {
+				return false
+			}
+		case PRED_ACCEPT, PRED_CONTENT_TYPE:
+			if p.Value == "" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+/* Order independent identity of a predicate set, used to replace/unregister */
+func predicateKey(preds []Predicate) string {
+	parts := make([]string, 0, len(preds))
+	for _, p := range preds {
+		name := p.Name
+		value := p.Value
+		switch p.Kind {
+		case PRED_HEADER:
+			name = strings.ToLower(name)
+		case PRED_ACCEPT, PRED_CONTENT_TYPE:
+			value = strings.ToLower(value)
+		}
+		parts = append(parts, string(rune('0'+p.Kind))+"\x00"+name+"\x00"+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\x01")
+}
+
+/* Copy-on-write insert, so tables handed out by Lookup stay immutable */
+func insertGuarded(list []guardedHandler, gh guardedHandler) []guardedHandler {
+	out := make([]guardedHandler, 0, len(list)+1)
+	replaced := false
+	for _, e := range list {
+		if e.key == gh.key {
+			out = append(out, gh)
+			replaced = true
+			continue
+		}
+		out = append(out, e)
+	}
+	if !replaced {
+		out = append(out, gh)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return len(out[i].preds) > len(out[j].preds)
+	})
+	return out
+}
+
+func removeGuarded(list []guardedHandler, key string) []guardedHandler {
+	out := make([]guardedHandler, 0, len(list))
+	for _, e := range list {
+		if e.key != key {
+			out = append(out, e)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func (t *HandlerTable) resolve(i int, ctx *fasthttp.RequestCtx) HTTPHandler {
+	for _, gh := range t.guarded[i] {
+		if matchAll(gh.preds, ctx) {
+			return gh.handler
+		}
+	}
+	return t.Handlers[i]
+}
+
+func matchAll(preds []Predicate, ctx *fasthttp.RequestCtx) bool {
+	for _, p := range preds {
+		if !p.match(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Predicate) match(ctx *fasthttp.RequestCtx) bool {
+	switch p.Kind {
+	case PRED_HEADER:
+		v, ok := PeekHeader(&ctx.Request.Header, p.Name)
+		return ok && (p.Value == "" || string(v) == p.Value)
+	case PRED_QUERY:
+		args := ctx.QueryArgs()
+		if !args.Has(p.Name) {
+			return false
+		}
+		return p.Value == "" || string(args.Peek(p.Name)) == p.Value
+	case PRED_ACCEPT:
+		v, ok := PeekHeader(&ctx.Request.Header, fasthttp.HeaderAccept)
+		return ok && acceptsMediaType(v, p.Value)
+	case PRED_CONTENT_TYPE:
+		return strings.EqualFold(mediaType(ctx.Request.Header.ContentType()), p.Value)
+	default:
+		return false
+	}
+}
+
+/* Header names are not normalized by the server, so compare them case-insensitively */
+func PeekHeader(h *fasthttp.RequestHeader, name string) ([]byte, bool) {
+	key := []byte(name)
+	for k, v := range h.All() {
+		if bytes.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func delHeader(h *fasthttp.RequestHeader, name string) {
+	key := []byte(name)
+	var found []string
+	for k := range h.All() {
+		if bytes.EqualFold(k, key) {
+			found = append(found, string(k))
+		}
+	}
+	for _, k := range found {
+		h.Del(k)
+	}
+}
+
+func mediaType(v []byte) string {
+	s := string(v)
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+/* A bare wildcard range is deliberately not a match, that is what the fallback handler is for */
+func acceptsMediaType(accept []byte, want string) bool {
+	wantMajor, _, _ := strings.Cut(want, "/")
+	for _, part := range bytes.Split(accept, []byte{','}) {
+		mt := mediaType(part)
+		if strings.EqualFold(mt, want) {
+			return true
+		}
+		major, minor, ok := strings.Cut(mt, "/")
+		if ok && minor == "*" && major != "*" && strings.EqualFold(major, wantMajor) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,8 +3,11 @@ package router
 import (
 	"context"
 	"net"
+	"omnirouter/internal/accesslog"
 	"omnirouter/internal/capabilities"
 	"omnirouter/internal/logger"
+	"omnirouter/internal/metrics"
+	"omnirouter/internal/tracing"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +31,13 @@ type HTTPHandler interface {
 
 type HandlerTable struct {
 	Handlers [methodCount]HTTPHandler
+	guarded  [methodCount][]guardedHandler
+	pattern  string /* set by Lookup */
+}
+
+/* The registered path the table was found under, wildcards end in `/*` */
+func (t HandlerTable) Pattern() string {
+	return t.pattern
 }
 
 func execForMethodBit(fn func(int), method_mask uint8) {
@@ -50,9 +60,12 @@ func execForMethodBit(fn func(int), method_mask uint8) {
 }
 
 type HTTPRouter interface {
-	Register(caps capabilities.Capabilities, methodMask uint8, path string, h HTTPHandler) uint64
-	Unregister(caps capabilities.Capabilities, methodMask uint8, path string) uint64
+	Register(caps capabilities.Set, methodMask uint8, path string, h HTTPHandler) uint64
+	Unregister(caps capabilities.Set, methodMask uint8, path string) uint64
+	RegisterGuarded(caps capabilities.Set, methodMask uint8, path string, preds []Predicate, h HTTPHandler) uint64
+	UnregisterGuarded(caps capabilities.Set, methodMask uint8, path string, preds []Predicate) uint64
 	Lookup(path string) (HandlerTable, bool)
+	Routes() []RouteInfo
 }
 
 func setup() {
@@ -101,6 +114,13 @@ type routeEntry struct {
 	wildcard bool
 }
 
+func (re *routeEntry) pattern(key string) string {
+	if !re.wildcard {
+		return key
+	}
+	return strings.TrimSuffix(key, "/") + "/*"
+}
+
 type radixRouter struct {
 	mu   sync.RWMutex
 	tree *radix.Tree
@@ -133,24 +153,36 @@ func (r *radixRouter) Lookup(path string) (HandlerTable, bool) {
 	raw := normalize(path)
 	r.mu.RLock()
 	if v, ok := r.tree.Get(raw); ok {
-		out := v.(*routeEntry).table
+		re := v.(*routeEntry)
+		out := re.table
+		out.pattern = re.pattern(raw)
 		r.mu.RUnlock()
 		return out, true
 	}
 
-	key, v, ok := r.tree.LongestPrefix(raw)
-	r.mu.RUnlock()
-	if !ok {
-		return HandlerTable{}, false
-	}
-	re := v.(*routeEntry)
-	if !re.wildcard {
+	/*
+	 * The deepest wildcard covering the path. Keys are only string prefixes,
+	 * /mnt is one of /mnt0 but must not shadow / for it.
+	 */
+	var (
+		key string
+		re  *routeEntry
+	)
+	r.tree.WalkPath(raw, func(k string, v any) bool {
+		e := v.(*routeEntry)
+		if e.wildcard && (k == "/" || raw == k || strings.HasPrefix(raw, k+"/")) {
+			key, re = k, e
+		}
+		return false
+	})
+	if re == nil {
+		r.mu.RUnlock()
 		return HandlerTable{}, false
 	}
-	if key == "/" || raw == key || strings.HasPrefix(raw, key+"/") {
-		return re.table, true
-	}
-	return HandlerTable{}, false
+	out := re.table
+	r.mu.RUnlock()
+	out.pattern = re.pattern(key)
+	return out, true
 }
 
 func startServer(addr string) (*fasthttp.Server, net.Listener, error) {
@@ -171,6 +203,7 @@ func startServer(addr string) (*fasthttp.Server, net.Listener, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	metrics.RegisterServer("main", s)
 	return s, ln, nil
 }
 
@@ -204,47 +237,68 @@ func RunServer(ctx context.Context, addr string) error {
 	}
 }
 
+const requestPathKey = "omnirouter.path"
+
+/* The canonical path the request was routed with */
+func RequestPath(ctx *fasthttp.RequestCtx) string {
+	if p, ok := ctx.UserValue(requestPathKey).(string); ok {
+		return p
+	}
+	return string(ctx.Path())
+}
+
 func dispatch(ctx *fasthttp.RequestCtx) {
-	switch string(ctx.Path()) {
+	start := time.Now()
+	route, module := metrics.ROUTE_INVALID, ""
+	span := startTrace(ctx)
+	defer func() {
+		echoTrace(ctx, span)
+		method, status, latency := string(ctx.Method()), ctx.Response.StatusCode(), time.Since(start)
+		metrics.ObserveRequest(route, method, status, module, latency)
+		accesslog.Log(ctx, accesslog.Entry{Route: route, Module: module, RequestID: span.RequestID, Latency: latency})
+		span.End(tracing.Attrs{Method: method, Path: RequestPath(ctx), Route: route, Status: status, Module: module})
+	}()
+
+	path, ok := canonicalPath(string(ctx.URI().PathOriginal()), getPathPolicy())
+	if !ok {
+		logger.WarnContext(ctx, "Rejected request path", "path", string(ctx.URI().PathOriginal()))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		return
+	}
+
+	route = metrics.ROUTE_RULE
+	path, matched, ok := applyRules(ctx, path)
+	if !ok {
+		return
+	}
+	defer applyResponseHeaders(ctx, matched)
+	ctx.SetUserValue(requestPathKey, path)
+
+	route = metrics.ROUTE_UNMATCHED
+	switch path {
 	case "/favicon.ico", "/robots.txt":
 		ctx.SetStatusCode(fasthttp.StatusNoContent)
 		return
 	}
 
-	path := string(ctx.Path())
-	logger.Debug("Looking up handlers for path", "path", path)
+	logger.DebugContext(ctx, "Looking up handlers for path", "path", path)
 
 	table, ok := GetHTTPRouter().Lookup(path)
 	if !ok {
 		ctx.SetStatusCode(fasthttp.StatusNotFound)
 		return
 	}
+	route = table.Pattern()
 
-	var methodBit uint8
-	switch string(ctx.Method()) {
-	case fasthttp.MethodGet:
-		methodBit = METHOD_GET
-	case fasthttp.MethodHead:
-		methodBit = METHOD_HEAD
-	case fasthttp.MethodPost:
-		methodBit = METHOD_POST
-	case fasthttp.MethodPut:
-		methodBit = METHOD_PUT
-	case fasthttp.MethodDelete:
-		methodBit = METHOD_DELETE
-	case fasthttp.MethodPatch:
-		methodBit = METHOD_PATCH
-	case fasthttp.MethodOptions:
-		methodBit = METHOD_OPTIONS
-	default:
-		methodBit = METHOD_UNKNOWN
-	}
+	methodBit := MethodBit(string(ctx.Method()))
 
 	execForMethodBit(func(i int) {
-		if table.Handlers[i] == nil {
+		h := table.resolve(i, ctx)
+		if h == nil {
 			return
 		}
+		module = handlerOwner(h)
 
-		table.Handlers[i].Invoke(ContextPtr(ctx), RequestPtr(nil))
+		h.Invoke(ContextPtr(ctx), RequestPtr(nil))
 	}, methodBit)
 }
@@ -0,0 +1,141 @@
+package router
+
+import (
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+type rule struct {
+	conf config.Rule
+	re   *regexp.Regexp
+}
+
+var rules atomic.Pointer[[]rule]
+
+/* Replaces the rule set, see config.Rule for the semantics */
+func SetRules(confs []config.Rule) error {
+	compiled := make([]rule, 0, len(confs))
+	for _, c := range confs {
+		r := rule{conf: c}
+		if c.Match != "" {
+			re, err := regexp.Compile(c.Match)
+			if err != nil {
+				return err
+			}
+			r.re = re
+		}
+		compiled = append(compiled, r)
+	}
+	rules.Store(&compiled)
+	return nil
+}
+
+/*
+ * Runs the rules over the canonical path. Returns the (possibly rewritten)
+ * path to look up and the matched rules whose response header operations
+ * still have to be applied once the handler ran, or false if a rule already
+ * produced the response.
+ */
+func applyRules(ctx *fasthttp.RequestCtx, path string) (string, []rule, bool) {
+	rs := rules.Load()
+	if rs == nil {
+		return path, nil, true
+	}
+
+	var matched []rule
+	for _, r := range *rs {
+		target, ok := r.match(path)
+		if !ok {
+			continue
+		}
+
+		r.applyRequestHeaders(ctx)
+		matched = append(matched, r)
+
+		switch r.conf.Action {
+		case config.RULE_REWRITE:
+			p, query, hasQuery := strings.Cut(target, "?")
+			rewritten, ok := canonicalPath(p, rewritePolicy())
+			if !ok {
+				logger.WarnContext(ctx, "Rewrite produced an invalid path", "path", path, "target", target)
+				ctx.SetStatusCode(fasthttp.StatusBadRequest)
+				return "", nil, false
+			}
+			if hasQuery {
+				ctx.URI().SetQueryString(query)
+			}
+			logger.DebugContext(ctx, "Rewrote request path", "from", path, "to", rewritten)
+			path = rewritten
+		case config.RULE_REDIRECT:
+			if !strings.Contains(target, "?") {
+				if q := ctx.URI().QueryString(); len(q) > 0 {
+					target += "?" + string(q)
+				}
+			}
+			ctx.Response.Header.Set(fasthttp.HeaderLocation, target)
+			ctx.SetStatusCode(r.conf.Status)
+			applyResponseHeaders(ctx, matched)
+			return "", nil, false
+		case config.RULE_RESPOND:
+			ctx.SetStatusCode(r.conf.Status)
+			ctx.SetContentType(r.conf.ContentType)
+			ctx.SetBodyString(r.conf.Body)
+			applyResponseHeaders(ctx, matched)
+			return "", nil, false
+		}
+
+		if r.conf.Stop {
+			break
+		}
+	}
+	return path, matched, true
+}
+
+/* Rewrite targets come from config, so they are not percent-decoded again */
+func rewritePolicy() PathPolicy {
+	p := getPathPolicy()
+	p.Decode = false
+	return p
+}
+
+func (r rule) match(path string) (string, bool) {
+	if r.re == nil {
+		if path != r.conf.Prefix && !strings.HasPrefix(path, strings.TrimSuffix(r.conf.Prefix, "/")+"/") {
+			return "", false
+		}
+		return strings.TrimSuffix(r.conf.Target, "/") + path[len(strings.TrimSuffix(r.conf.Prefix, "/")):], true
+	}
+
+	m := r.re.FindStringSubmatchIndex(path)
+	if m == nil {
+		return "", false
+	}
+	return string(r.re.ExpandString(nil, r.conf.Target, path, m)), true
+}
+
+func (r rule) applyRequestHeaders(ctx *fasthttp.RequestCtx) {
+	for _, k := range r.conf.RemoveRequestHeaders {
+		delHeader(&ctx.Request.Header, k)
+	}
+	for k, v := range r.conf.SetRequestHeaders {
+		delHeader(&ctx.Request.Header, k)
+		ctx.Request.Header.Set(k, v)
+	}
+}
+
+/* After the handler, so the response it produced does not drop them */
+func applyResponseHeaders(ctx *fasthttp.RequestCtx, matched []rule) {
+	for _, r := range matched {
+		for _, k := range r.conf.RemoveHeaders {
+			ctx.Response.Header.Del(k)
+		}
+		for k, v := range r.conf.SetHeaders {
+			ctx.Response.Header.Set(k, v)
+		}
+	}
+}
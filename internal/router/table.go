@@ -0,0 +1,171 @@
+package router
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+/* Implemented by handlers registered on behalf of a module */
+type OwnedHandler interface {
+	HTTPHandler
+	Owner() string
+}
+
+/*
+ * One registration as seen by the route table: the methods of Path served by
+ * the same owner under the same predicates. Owner is the module name, empty
+ * for routes of the router itself (proxy, static, gateway).
+ */
+type RouteInfo struct {
+	Path       string   `json:"path"`
+	Wildcard   bool     `json:"wildcard"`
+	Methods    []string `json:"methods"`
+	Owner      string   `json:"owner,omitempty"`
+	Predicates []string `json:"predicates,omitempty"`
+}
+
+var methodNames = [methodCount]string{"", "GET", "HEAD", "POST", "PUT", "DELETE", "PATCH"}
+
+func handlerOwner(h HTTPHandler) string {
+	if o, ok := h.(OwnedHandler); ok {
+		return o.Owner()
+	}
+	return ""
+}
+
+func predicateStrings(preds []Predicate) []string {
+	out := make([]string, 0, len(preds))
+	for _, p := range preds {
+		switch p.Kind {
+		case PRED_HEADER:
+			out = append(out, "header:"+p.Name+"="+p.Value)
+		case PRED_QUERY:
+			out = append(out, "query:"+p.Name+"="+p.Value)
+		case PRED_ACCEPT:
+			out = append(out, "accept:"+p.Value)
+		case PRED_CONTENT_TYPE:
+			out = append(out, "content_type:"+p.Value)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+/* Snapshot of the registered routes, sorted by path */
+func (r *radixRouter) Routes() []RouteInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var out []RouteInfo
+	r.tree.Walk(func(path string, v interface{}) bool {
+		re := v.(*routeEntry)
+		idx := make(map[string]int)
+		add := func(i int, h HTTPHandler, preds []Predicate) {
+			owner := handlerOwner(h)
+			ps := predicateStrings(preds)
+			key := owner + "\x00" + strings.Join(ps, "\x00")
+			j, ok := idx[key]
+			if !ok {
+				j = len(out)
+				idx[key] = j
+				out = append(out, RouteInfo{Path: path, Wildcard: re.wildcard, Owner: owner, Predicates: ps})
+			}
+			out[j].Methods = append(out[j].Methods, methodNames[i])
+		}
+
+		for i := range methodCount {
+			if methodNames[i] == "" {
+				continue
+			}
+			if h := re.table.Handlers[i]; h != nil {
+				add(i, h, nil)
+			}
+			for _, gh := range re.table.guarded[i] {
+				add(i, gh.handler, gh.preds)
+			}
+		}
+		return false
+	})
+	return out
+}
+
+/* One line per registration, `GET,HEAD /path/* owner [predicates]` */
+func (ri RouteInfo) String() string {
+	path := ri.Path
+	if ri.Wildcard {
+		path = strings.TrimSuffix(path, "/") + "/*"
+	}
+	owner := ri.Owner
+	if owner == "" {
+		owner = "-"
+	}
+	s := strings.Join(ri.Methods, ",") + " " + path + " " + owner
+	if len(ri.Predicates) > 0 {
+		s += " [" + strings.Join(ri.Predicates, " ") + "]"
+	}
+	return s
+}
+
+/* Registrations only in next (added) and only in prev (removed), in path order */
+func DiffRoutes(prev, next []RouteInfo) (added, removed []RouteInfo) {
+	seen := make(map[string]bool, len(prev))
+	for _, ri := range prev {
+		seen[ri.String()] = true
+	}
+	kept := make(map[string]bool, len(next))
+	for _, ri := range next {
+		s := ri.String()
+		kept[s] = true
+		if !seen[s] {
+			added = append(added, ri)
+		}
+	}
+	for _, ri := range prev {
+		if !kept[ri.String()] {
+			removed = append(removed, ri)
+		}
+	}
+	return added, removed
+}
+
+const (
+	ROUTE_REGISTERED   = "registered"
+	ROUTE_UNREGISTERED = "unregistered"
+)
+
+/* A registration or removal as it happened, Owner as in RouteInfo */
+type RouteChange struct {
+	Action     string   `json:"action"`
+	Path       string   `json:"path"`
+	Wildcard   bool     `json:"wildcard"`
+	Methods    []string `json:"methods"`
+	Owner      string   `json:"owner,omitempty"`
+	Predicates []string `json:"predicates,omitempty"`
+}
+
+var routeListener atomic.Pointer[func(RouteChange)]
+
+/* fn is called after every successful change, outside the router lock */
+func SetRouteListener(fn func(RouteChange)) {
+	routeListener.Store(&fn)
+}
+
+func notifyRoute(c *RouteChange) {
+	if c == nil {
+		return
+	}
+	if fn := routeListener.Load(); fn != nil {
+		(*fn)(*c)
+	}
+}
+
+func methodList(mask uint8) []string {
+	var out []string
+	execForMethodBit(func(i int) {
+		if i < len(methodNames) && methodNames[i] != "" {
+			out = append(out, methodNames[i])
+		}
+	}, mask)
+	return out
+}
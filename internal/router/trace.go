@@ -0,0 +1,62 @@
+package router
+
+import (
+	"omnirouter/internal/logger"
+	"omnirouter/internal/tracing"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	HEADER_REQUEST_ID  = "X-Request-ID"
+	HEADER_TRACEPARENT = "traceparent"
+
+	traceKey = "omnirouter.trace"
+)
+
+/*
+ * Takes over the request ID and trace context of the request or starts new
+ * ones. The request headers are replaced with ours, so upstreams, gateways
+ * and modules see our span as the parent, and every log line made with the
+ * request's context carries the request ID.
+ */
+func startTrace(ctx *fasthttp.RequestCtx) *tracing.Span {
+	tp, _ := PeekHeader(&ctx.Request.Header, HEADER_TRACEPARENT)
+	rid, _ := PeekHeader(&ctx.Request.Header, HEADER_REQUEST_ID)
+	span := tracing.Start(tp, rid)
+
+	ctx.SetUserValue(traceKey, span)
+	logger.Bind(ctx, logger.REQUEST_ID_KEY, span.RequestID)
+
+	delHeader(&ctx.Request.Header, HEADER_TRACEPARENT)
+	delHeader(&ctx.Request.Header, HEADER_REQUEST_ID)
+	ctx.Request.Header.Set(HEADER_TRACEPARENT, span.Traceparent())
+	ctx.Request.Header.Set(HEADER_REQUEST_ID, span.RequestID)
+	return span
+}
+
+/* Set last, handlers (the proxy in particular) may replace the response headers */
+func echoTrace(ctx *fasthttp.RequestCtx, span *tracing.Span) {
+	ctx.Response.Header.Set(HEADER_REQUEST_ID, span.RequestID)
+	ctx.Response.Header.Set(HEADER_TRACEPARENT, span.Traceparent())
+}
+
+/* Trace context of the request, nil outside of dispatch */
+func Trace(ctx *fasthttp.RequestCtx) *tracing.Span {
+	span, _ := ctx.UserValue(traceKey).(*tracing.Span)
+	return span
+}
+
+func RequestID(ctx *fasthttp.RequestCtx) string {
+	if span := Trace(ctx); span != nil {
+		return span.RequestID
+	}
+	return ""
+}
+
+func Traceparent(ctx *fasthttp.RequestCtx) string {
+	if span := Trace(ctx); span != nil {
+		return span.Traceparent()
+	}
+	return ""
+}
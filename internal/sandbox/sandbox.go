@@ -0,0 +1,25 @@
+package sandbox
+
+import (
+	"omnirouter/internal/config"
+	"os/exec"
+)
+
+/*
+ * Module workers are confined by re-executing the router binary as a small
+ * launcher (EXEC_ARG) that applies the policy from POLICY_ENV to itself and
+ * then execs the worker, which inherits all of it. main() has to call
+ * MaybeExec before doing anything else.
+ */
+const (
+	EXEC_ARG   = "--omnirouter-sandbox-exec"
+	POLICY_ENV = "OMNIROUTER_SANDBOX_POLICY"
+)
+
+/* The command starting `target`, confined by `sb` if it is enabled */
+func Command(target string, sb config.Sandbox) (*exec.Cmd, error) {
+	if !sb.Enabled {
+		return exec.Command(target), nil
+	}
+	return command(target, sb)
+}
@@ -0,0 +1,122 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"omnirouter/internal/config"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func command(target string, sb config.Sandbox) (*exec.Cmd, error) {
+	/* Catch policy errors here rather than as an exit code of the launcher */
+	if sb.Seccomp != config.SECCOMP_OFF {
+		if _, err := seccompFilter(sb); err != nil {
+			return nil, err
+		}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := json.Marshal(sb)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(self, EXEC_ARG, target)
+	cmd.Env = append(environ(sb), POLICY_ENV+"="+string(policy))
+
+	attr := &syscall.SysProcAttr{}
+	/* An unprivileged network namespace needs a user namespace to own it */
+	if sb.UserNamespace || (sb.NetworkNamespace && os.Geteuid() != 0) {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+	if sb.NetworkNamespace {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = attr
+	return cmd, nil
+}
+
+/* PATH and the allowlisted variables, nothing else of the router's environment */
+func environ(sb config.Sandbox) []string {
+	env := []string{}
+	seen := map[string]bool{}
+	for _, name := range append([]string{"PATH"}, sb.Env...) {
+		if v, ok := os.LookupEnv(name); ok && !seen[name] {
+			seen[name] = true
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
+
+/* Becomes the confined worker if this process was started by Command */
+func MaybeExec() {
+	if len(os.Args) != 3 || os.Args[1] != EXEC_ARG {
+		return
+	}
+
+	/* Landlock, no_new_privs and seccomp apply to the calling thread, which then execs */
+	runtime.LockOSThread()
+	err := confine(os.Args[2])
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	os.Exit(126)
+}
+
+func confine(target string) error {
+	var sb config.Sandbox
+	if err := json.Unmarshal([]byte(os.Getenv(POLICY_ENV)), &sb); err != nil {
+		return fmt.Errorf("invalid policy: %w", err)
+	}
+	os.Unsetenv(POLICY_ENV)
+
+	if err := setRlimits(sb); err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("no_new_privs: %w", err)
+	}
+	if err := restrictFS(target, sb); err != nil {
+		return err
+	}
+	/* Last, so nothing above runs into the filter */
+	if sb.Seccomp != config.SECCOMP_OFF {
+		if err := installSeccomp(sb); err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(target, []string{target}, os.Environ())
+}
+
+func setRlimits(sb config.Sandbox) error {
+	limits := []struct {
+		resource int
+		value    uint64
+		name     string
+	}{
+		{unix.RLIMIT_AS, sb.MaxMemory, "max_memory"},
+		{unix.RLIMIT_CPU, uint64((sb.MaxCPU + 999_999_999) / 1_000_000_000), "max_cpu"},
+		{unix.RLIMIT_NOFILE, sb.MaxFiles, "max_files"},
+	}
+	for _, l := range limits {
+		if l.value == 0 {
+			continue
+		}
+		if err := unix.Setrlimit(l.resource, &unix.Rlimit{Cur: l.value, Max: l.value}); err != nil {
+			return fmt.Errorf("%s: %w", l.name, err)
+		}
+	}
+	return nil
+}
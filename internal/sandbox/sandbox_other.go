@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"omnirouter/internal/config"
+	"os/exec"
+)
+
+func command(target string, sb config.Sandbox) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("module sandboxing is only supported on Linux")
+}
+
+func MaybeExec() {}
@@ -0,0 +1,102 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"omnirouter/internal/config"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+ * Always denied: kernel/module management, mounts and namespaces, tracing
+ * other processes, clock changes and the like. Names that do not exist on
+ * the running architecture are ignored.
+ */
+var defaultDeniedSyscalls = []string{
+	"acct", "add_key", "adjtimex", "bpf", "chroot", "clock_adjtime", "clock_settime",
+	"create_module", "delete_module", "finit_module", "fsconfig", "fsmount", "fsopen",
+	"fspick", "get_kernel_syms", "init_module", "ioperm", "iopl", "kexec_file_load",
+	"kexec_load", "keyctl", "lookup_dcookie", "mount", "mount_setattr", "move_mount",
+	"name_to_handle_at", "nfsservctl", "open_by_handle_at", "open_tree", "perf_event_open",
+	"personality", "pivot_root", "process_vm_readv", "process_vm_writev", "ptrace",
+	"query_module", "quotactl", "reboot", "request_key", "setdomainname", "sethostname",
+	"setns", "settimeofday", "swapoff", "swapon", "syslog", "umount2", "unshare",
+	"uselib", "userfaultfd", "vhangup",
+}
+
+func seccompFilter(sb config.Sandbox) ([]unix.SockFilter, error) {
+	if auditArch == 0 {
+		return nil, fmt.Errorf("seccomp filters are not supported on this architecture")
+	}
+
+	action := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	if sb.Seccomp == config.SECCOMP_KILL {
+		action = unix.SECCOMP_RET_KILL_PROCESS
+	}
+
+	seen := make(map[uint32]bool)
+	var denied []uint32
+	for _, name := range defaultDeniedSyscalls {
+		if nr, ok := syscallNumbers[name]; ok && !seen[nr] {
+			seen[nr] = true
+			denied = append(denied, nr)
+		}
+	}
+	for _, name := range sb.DenySyscalls {
+		nr, ok := syscallNumbers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown syscall %q in deny_syscalls", name)
+		}
+		if name == "execve" || name == "execveat" {
+			return nil, fmt.Errorf("%q can not be denied, the worker is started with it", name)
+		}
+		if !seen[nr] {
+			seen[nr] = true
+			denied = append(denied, nr)
+		}
+	}
+
+	prog := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 4), /* seccomp_data.arch */
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 0), /* seccomp_data.nr */
+	}
+	if x32SyscallBit != 0 {
+		prog = append(prog,
+			bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		)
+	}
+	for _, nr := range denied {
+		prog = append(prog,
+			bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, action),
+		)
+	}
+	prog = append(prog, bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW))
+	return prog, nil
+}
+
+func installSeccomp(sb config.Sandbox) error {
+	filter, err := seccompFilter(sb)
+	if err != nil {
+		return err
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("seccomp: %w", err)
+	}
+	return nil
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
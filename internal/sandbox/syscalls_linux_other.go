@@ -0,0 +1,10 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+/* No syscall table for this architecture, a seccomp policy fails to build */
+var syscallNumbers = map[string]uint32{}
+
+const auditArch = 0
+
+const x32SyscallBit = 0
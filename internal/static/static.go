@@ -0,0 +1,163 @@
+package static
+
+import (
+	"fmt"
+	"io/fs"
+	"omnirouter/internal/capabilities"
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"omnirouter/internal/router"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+type handler struct {
+	prefix string
+	root   string
+	index  []string
+	cache  []config.CacheRule
+	serve  fasthttp.RequestHandler
+}
+
+var _ router.HTTPHandler = (*handler)(nil)
+
+/* Registers every `static` kind route */
+func Setup(conf *config.Config) {
+	for _, r := range conf.Routes {
+		if r.Kind != config.ROUTE_STATIC {
+			continue
+		}
+
+		mask, err := router.ParseMethods(r.Methods)
+		if err != nil {
+			logger.Error("Invalid methods in route config", "path", r.Path, "root", r.Static.Root, "err", err)
+			continue
+		}
+
+		h := newHandler(r.Path, r.Static)
+		router.GetHTTPRouter().Register(capabilities.Unrestricted(), mask, r.Pattern(), h)
+		logger.Info("Serving static files", "path", r.Path, "root", r.Static.Root)
+	}
+}
+
+func newHandler(prefix string, st config.Static) *handler {
+	index := st.Index
+	if len(index) == 0 {
+		index = []string{"index.html"}
+	}
+
+	h := &handler{
+		prefix: prefix,
+		root:   st.Root,
+		index:  index,
+		cache:  st.CacheControl,
+	}
+
+	/* A non-OS fs.FS makes fasthttp keep compressed files in memory instead of writing them next to the originals */
+	fsys := &fasthttp.FS{
+		FS:                 os.DirFS(st.Root),
+		Root:               "",
+		IndexNames:         index,
+		GenerateIndexPages: st.Listing,
+		AcceptByteRange:    st.ByteRanges,
+		Compress:           st.Compress,
+		CompressBrotli:     st.Compress,
+		/* fasthttp ignores the map unless every encoding has a distinct suffix */
+		CompressedFileSuffixes: map[string]string{"gzip": ".gz", "br": ".br", "zstd": ".zst"},
+		PathRewrite:            h.relativePath,
+	}
+	h.serve = fsys.NewRequestHandler()
+	return h
+}
+
+const fsPathKey = "omnirouter.static.path"
+
+/* Canonical paths have no trailing slash, fasthttp wants one on directories */
+func (h *handler) relativePath(ctx *fasthttp.RequestCtx) []byte {
+	if p, ok := ctx.UserValue(fsPathKey).(string); ok {
+		return []byte(p)
+	}
+	return []byte(router.StripMount(h.prefix, router.RequestPath(ctx)))
+}
+
+func (h *handler) Invoke(cptr router.ContextPtr, _ router.RequestPtr) {
+	ctx := (*fasthttp.RequestCtx)(cptr)
+	rel := router.StripMount(h.prefix, router.RequestPath(ctx))
+	if fi, err := os.Stat(filepath.Join(h.root, filepath.FromSlash(rel))); err == nil && fi.IsDir() && rel != "/" {
+		ctx.SetUserValue(fsPathKey, rel+"/")
+	} else {
+		ctx.SetUserValue(fsPathKey, rel)
+	}
+
+	name, fi := h.resolve(rel)
+	etag := ""
+	if fi != nil {
+		etag = fmt.Sprintf(`W/"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+		if inm, ok := router.PeekHeader(&ctx.Request.Header, fasthttp.HeaderIfNoneMatch); ok && etagMatches(string(inm), etag) {
+			ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+			h.setCacheControl(ctx, name)
+			ctx.SetStatusCode(fasthttp.StatusNotModified)
+			return
+		}
+	}
+
+	h.serve(ctx)
+
+	switch ctx.Response.StatusCode() {
+	case fasthttp.StatusOK, fasthttp.StatusPartialContent, fasthttp.StatusNotModified:
+		if etag != "" {
+			ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+		}
+		h.setCacheControl(ctx, name)
+	}
+}
+
+/* Finds the file a request path maps to, following index names for directories */
+func (h *handler) resolve(rel string) (string, fs.FileInfo) {
+	name := filepath.Join(h.root, filepath.FromSlash(rel))
+	fi, err := os.Stat(name)
+	if err != nil {
+		return "", nil
+	}
+	if !fi.IsDir() {
+		return rel, fi
+	}
+
+	for _, idx := range h.index {
+		ifi, err := os.Stat(filepath.Join(name, idx))
+		if err == nil && !ifi.IsDir() {
+			return path.Join(rel, idx), ifi
+		}
+	}
+	return "", nil
+}
+
+func (h *handler) setCacheControl(ctx *fasthttp.RequestCtx, name string) {
+	if name == "" {
+		return
+	}
+	for _, rule := range h.cache {
+		target := path.Base(name)
+		if strings.Contains(rule.Match, "/") {
+			target = name
+		}
+		if ok, _ := path.Match(rule.Match, target); ok {
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, rule.Value)
+			return
+		}
+	}
+}
+
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag || "W/"+tag == etag {
+			return true
+		}
+	}
+	return false
+}
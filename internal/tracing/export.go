@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"omnirouter/internal/config"
+	"omnirouter/internal/logger"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+/*
+ * Spans of sampled requests are appended to a local file in the OTLP/JSON
+ * file format: one ExportTraceServiceRequest per line, each holding a batch
+ * of spans. The file is rotated like the log, spans are dropped rather than
+ * slowing requests down when the writer falls behind.
+ */
+const (
+	defaultService = "omnirouter"
+	scopeName      = "omnirouter"
+
+	queueSize     = 4096
+	maxBatch      = 256
+	flushInterval = time.Second
+
+	maxSizeMB  = 64
+	maxBackups = 5
+	maxAgeDays = 30
+
+	SPAN_KIND_SERVER = 2
+	STATUS_ERROR     = 2
+)
+
+/* What the router knows about a request once it has been handled */
+type Attrs struct {
+	Method string
+	Path   string
+	Route  string
+	Status int
+	Module string
+}
+
+type sink struct {
+	service string
+	queue   chan otlpSpan
+	dropped atomic.Uint64
+}
+
+var current atomic.Pointer[sink]
+
+func Setup(ctx context.Context, conf *config.Config) {
+	tc := conf.Tracing
+	if tc.File == "" {
+		return
+	}
+
+	s := &sink{service: tc.Service, queue: make(chan otlpSpan, queueSize)}
+	if s.service == "" {
+		s.service = defaultService
+	}
+	w := &lumberjack.Logger{
+		Filename:   tc.File,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAgeDays,
+	}
+	current.Store(s)
+	go s.run(ctx, w)
+	logger.Info("Exporting spans", "file", tc.File, "service", s.service)
+}
+
+/* Queues the span for export, a no-op without a sink or for unsampled traces */
+func (s *Span) End(a Attrs) {
+	k := current.Load()
+	if k == nil || !s.Sampled() {
+		return
+	}
+
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.SpanID[:]),
+		Name:              a.Method + " " + a.Route,
+		Kind:              SPAN_KIND_SERVER,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes: []otlpKeyValue{
+			stringAttr("http.request.method", a.Method),
+			stringAttr("url.path", a.Path),
+			stringAttr("http.route", a.Route),
+			intAttr("http.response.status_code", a.Status),
+			stringAttr("omnirouter.request_id", s.RequestID),
+		},
+	}
+	if s.ParentID != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+	}
+	if a.Module != "" {
+		span.Attributes = append(span.Attributes, stringAttr("omnirouter.module", a.Module))
+	}
+	if a.Status >= 500 {
+		span.Status = &otlpStatus{Code: STATUS_ERROR}
+	}
+
+	select {
+	case k.queue <- span:
+	default:
+		k.dropped.Add(1)
+	}
+}
+
+func (k *sink) run(ctx context.Context, w *lumberjack.Logger) {
+	defer w.Close()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]otlpSpan, 0, maxBatch)
+	flush := func() {
+		if n := k.dropped.Swap(0); n > 0 {
+			logger.Warn("Span export queue full, spans dropped", "dropped", n)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := k.write(w, batch); err != nil {
+			logger.Error("Could not write spans", "err", err.Error())
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-k.queue:
+			batch = append(batch, span)
+			if len(batch) >= maxBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			current.CompareAndSwap(k, nil)
+			for {
+				select {
+				case span := <-k.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (k *sink) write(w *lumberjack.Logger, spans []otlpSpan) error {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{stringAttr("service.name", k.service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: scopeName},
+			Spans: spans,
+		}},
+	}}}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+/* The subset of the OTLP/JSON trace encoding we produce, IDs are hex, 64 bit integers strings */
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func stringAttr(key, v string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpValue{StringValue: &v}}
+}
+
+func intAttr(key string, v int) otlpKeyValue {
+	s := strconv.Itoa(v)
+	return otlpKeyValue{Key: key, Value: otlpValue{IntValue: &s}}
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+/*
+ * W3C trace context (https://www.w3.org/TR/trace-context/) of a request. An
+ * incoming `traceparent` continues the caller's trace with a new span of
+ * ours, otherwise a new, sampled trace is started. The request ID is the
+ * incoming X-Request-ID if usable, the trace ID otherwise.
+ */
+type Span struct {
+	TraceID   [16]byte
+	SpanID    [8]byte
+	ParentID  [8]byte /* zero for a root span */
+	Flags     byte
+	RequestID string
+	Start     time.Time
+}
+
+const (
+	FLAG_SAMPLED = 0x01
+
+	traceparentLen = 55
+	maxRequestID   = 128
+)
+
+func Start(traceparent, requestID []byte) *Span {
+	s := &Span{Start: time.Now()}
+	if trace, parent, flags, ok := ParseTraceparent(traceparent); ok {
+		s.TraceID, s.ParentID, s.Flags = trace, parent, flags
+	} else {
+		rand.Read(s.TraceID[:])
+		s.Flags = FLAG_SAMPLED
+	}
+	rand.Read(s.SpanID[:])
+
+	if validRequestID(requestID) {
+		s.RequestID = string(requestID)
+	} else {
+		s.RequestID = hex.EncodeToString(s.TraceID[:])
+	}
+	return s
+}
+
+/* Version 00 `traceparent`, all-zero trace or parent IDs are invalid */
+func ParseTraceparent(b []byte) (trace [16]byte, parent [8]byte, flags byte, ok bool) {
+	if len(b) < traceparentLen || b[2] != '-' || b[35] != '-' || b[52] != '-' {
+		return
+	}
+	/* Later versions may append fields, version ff is invalid */
+	if string(b[:2]) == "ff" || (string(b[:2]) == "00" && len(b) != traceparentLen) {
+		return
+	}
+
+	var v [1]byte
+	if !decodeHex(v[:], b[:2]) || !decodeHex(trace[:], b[3:35]) || !decodeHex(parent[:], b[36:52]) {
+		return
+	}
+	var f [1]byte
+	if !decodeHex(f[:], b[53:55]) {
+		return
+	}
+	if trace == [16]byte{} || parent == [8]byte{} {
+		return
+	}
+	return trace, parent, f[0], true
+}
+
+/* Our span as the parent of whatever is called on behalf of the request */
+func (s *Span) Traceparent() string {
+	return "00-" + hex.EncodeToString(s.TraceID[:]) + "-" + hex.EncodeToString(s.SpanID[:]) + "-" + hexByte(s.Flags)
+}
+
+func (s *Span) Sampled() bool { return s.Flags&FLAG_SAMPLED != 0 }
+
+/* Lower case hex only, as the spec requires */
+func decodeHex(dst, src []byte) bool {
+	for _, c := range src {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	_, err := hex.Decode(dst, src)
+	return err == nil
+}
+
+func hexByte(b byte) string {
+	s := strconv.FormatUint(uint64(b), 16)
+	if len(s) == 1 {
+		return "0" + s
+	}
+	return s
+}
+
+/* Printable ASCII without spaces, so it is safe in headers and log lines */
+func validRequestID(b []byte) bool {
+	if len(b) == 0 || len(b) > maxRequestID {
+		return false
+	}
+	for _, c := range b {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
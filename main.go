@@ -2,10 +2,18 @@ package main
 
 import (
 	"context"
+	"omnirouter/internal/accesslog"
+	"omnirouter/internal/admin"
 	"omnirouter/internal/config"
+	"omnirouter/internal/gateway"
+	"omnirouter/internal/health"
 	"omnirouter/internal/logger"
 	"omnirouter/internal/modmgr"
+	"omnirouter/internal/proxy"
 	"omnirouter/internal/router"
+	"omnirouter/internal/sandbox"
+	"omnirouter/internal/static"
+	"omnirouter/internal/tracing"
 	"os"
 	"os/signal"
 	"syscall"
@@ -14,6 +22,13 @@ import (
 )
 
 func main() {
+	/* Launcher of a sandboxed module worker, execs the worker and never returns */
+	sandbox.MaybeExec()
+
+	if len(os.Args) > 1 && os.Args[1] == "routes" {
+		os.Exit(routesCommand(os.Args[2:]))
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
@@ -25,10 +40,43 @@ func main() {
 		println("Missing required values in config, please see the log for further details")
 		return
 	}
+	level, _ := logger.ParseLevel(conf.Log.Level)
+	logger.SetLevel(level)
+	logger.SetBufferSize(conf.Log.Buffer)
+	logger.HandleSignals(ctx)
+	tracing.Setup(ctx, conf)
+	accesslog.Setup(conf)
+	router.SetPathPolicy(pathPolicy(conf.Router.Paths))
+	if err := router.SetRules(conf.Rules); err != nil {
+		logger.Error("Invalid rules", "err", err)
+		return
+	}
 	modmgr.InitMUID64Map()
+	modmgr.SetModuleConfigs(conf.Module)
+	modmgr.SetRouteConfigs(conf.Routes)
 	modmgr.SetMirrorDir(conf.Modules.Mirrorlib)
+	modmgr.LoadNativeModules()
 	modmgr.LookForChanges(ctx, "examples/c/hello_world/")
+	proxy.Setup(ctx, conf)
+	static.Setup(conf)
+	gateway.Setup(conf)
+	health.Setup(conf)
+	router.ServeMetrics(conf.Router.Metrics)
+	admin.Setup(ctx, conf)
+	health.MarkStarted()
 	router.RunServer(ctx, ":8080")
 
 	<-ctx.Done()
 }
+
+func pathPolicy(paths config.Paths) router.PathPolicy {
+	slash, _ := router.ParseEncodedSlashPolicy(paths.EncodedSlash)
+	dots, _ := router.ParseDotSegmentPolicy(paths.DotSegments)
+	return router.PathPolicy{
+		Decode:          paths.Decode,
+		CollapseSlashes: paths.CollapseSlashes,
+		RejectControl:   paths.RejectControl,
+		EncodedSlash:    slash,
+		DotSegments:     dots,
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Native Go modules. A package compiled into the router registers its module
+ * from an init function:
+ *
+ *	func init() { omnimod.Register("billing", &billing{}) }
+ *
+ * and is configured under [module.billing] like any dynamic module: it gets
+ * a MUID, its capabilities and mount apply to everything it registers, and it
+ * shows up in the module listing with type "native".
+ */
+package omnimod
+
+import (
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+/* Same lifecycle as the C ABI's init/uninit, false marks the module as failed */
+type Module interface {
+	Init(api API) bool
+	Uninit(api API) bool
+}
+
+/* Optional, resolves the `handler` of [[routes]] entries pointing to the module */
+type HandlerProvider interface {
+	Handler(name string) (Handler, bool)
+}
+
+/* Only valid for the duration of the handler call, like or_http_req_t */
+type Request struct {
+	Path        string /* full canonical request path */
+	MountPath   string /* same path relative to the module's mount */
+	RequestID   string /* X-Request-ID of the request, echoed in the response */
+	Traceparent string /* W3C trace context, our span as parent */
+}
+
+type Handler func(ctx *fasthttp.RequestCtx, req *Request)
+
+/* Same values as or_predicate_kind_t in cffi.h */
+type PredicateKind uint8
+
+const (
+	PRED_HEADER       PredicateKind = 1
+	PRED_QUERY        PredicateKind = 2
+	PRED_ACCEPT       PredicateKind = 3
+	PRED_CONTENT_TYPE PredicateKind = 4
+)
+
+/* Narrows a registration down like or_predicate_t, see RegisterHTTPEx */
+type Predicate struct {
+	Kind  PredicateKind
+	Name  string
+	Value string
+}
+
+/* Same bits as or_http_method_t in cffi.h */
+const (
+	METHOD_GET     uint8 = 1 << 1
+	METHOD_HEAD    uint8 = 1 << 2
+	METHOD_POST    uint8 = 1 << 3
+	METHOD_PUT     uint8 = 1 << 4
+	METHOD_DELETE  uint8 = 1 << 5
+	METHOD_PATCH   uint8 = 1 << 6
+	METHOD_OPTIONS uint8 = 1 << 7
+	METHOD_ANY     uint8 = ^uint8(0)
+)
+
+const (
+	LOG_INFO  uint32 = 0
+	LOG_WARN  uint32 = 1
+	LOG_ERROR uint32 = 2
+	LOG_FATAL uint32 = 3
+)
+
+/* Module reported health, HEALTH_UNHEALTHY fails readiness of a required module */
+const (
+	HEALTH_OK        uint32 = 0
+	HEALTH_DEGRADED  uint32 = 1
+	HEALTH_UNHEALTHY uint32 = 2
+)
+
+const (
+	METRIC_COUNTER   uint32 = 1
+	METRIC_GAUGE     uint32 = 2
+	METRIC_HISTOGRAM uint32 = 3
+)
+
+/* The Go side of or_api_t, return codes are the router's (0 on success) */
+type API interface {
+	MUID() uint64
+	Log(level uint32, msg string)
+	LogRequest(req *Request, level uint32, msg string) /* tagged with the request ID */
+	RegisterHTTP(methodMask uint8, path string, h Handler) uint64
+	UnregisterHTTP(methodMask uint8, path string) uint64
+	RegisterHTTPEx(methodMask uint8, path string, preds []Predicate, h Handler) uint64
+	UnregisterHTTPEx(methodMask uint8, path string, preds []Predicate) uint64
+	ReportHealth(status uint32, detail string) uint64
+
+	/* See or_metric_kind_t in cffi.h, NewMetric returns 0 on failure */
+	NewMetric(kind uint32, name, help string, labels []string, buckets []float64) uint64
+	AddMetric(metric uint64, value float64, labelValues ...string) uint64
+	SetMetric(metric uint64, value float64, labelValues ...string) uint64
+	ObserveMetric(metric uint64, value float64, labelValues ...string) uint64
+}
+
+type Registration struct {
+	Name   string
+	Module Module
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []Registration
+)
+
+/* Meant for init functions, modules are loaded in registration order at startup */
+func Register(name string, m Module) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, r := range registry {
+		if r.Name == name {
+			panic("omnimod: module " + name + " registered twice")
+		}
+	}
+	registry = append(registry, Registration{Name: name, Module: m})
+}
+
+func Registered() []Registration {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return append([]Registration(nil), registry...)
+}